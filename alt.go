@@ -0,0 +1,133 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Match: ![Architecture](render-db6d08bb.svg)
+// Capture group on the escaped alt text.
+var markdownImageAltRegexp = regexp.MustCompile(`!\[((?:[^\\\]]|\\.)*)\]\(`)
+
+// Match: <img src="render-db6d08bb.svg" alt="Architecture">
+// Capture group on the escaped alt text.
+var htmlImageAltRegexp = regexp.MustCompile(`<img\s[^>]*\balt="([^"]*)"`)
+
+// Match: image::render-db6d08bb.svg["Architecture, overview",hash=32455c4f]
+// Capture group on the escaped alt text.
+var asciiDocImageAltRegexp = regexp.MustCompile(`image::[^\[\s]*\[("(?:[^"\\]|\\.)*"|[^,\]]*)`)
+
+// The comment delimiters of each language, used to find the leading comment
+// of a code block with --alt-from-comment. The end delimiter is empty for line
+// comments. Block comments come first, so that /' is not taken for '.
+var commentDelimiters = map[string][][2]string{
+	"dot":         {{"/*", "*/"}, {"//", ""}, {"#", ""}},
+	"plantuml":    {{"/'", "'/"}, {"'", ""}},
+	"pikchr":      {{"/*", "*/"}, {"//", ""}, {"#", ""}},
+	"mscgen":      {{"/*", "*/"}, {"//", ""}, {"#", ""}},
+	"math":        {{"%", ""}},
+	"erd":         {{"#", ""}},
+	"python-plot": {{"#", ""}},
+	"structurizr": {{"/*", "*/"}, {"//", ""}, {"#", ""}},
+	"chemfig":     {{"%", ""}},
+}
+
+// AltText returns the alt text of the chunk's image: the alt text of the
+// locale set with --locale if any, then the alt option, then the leading
+// comment of the code block with --alt-from-comment, then the filename.
+func (r *Chunk) AltText(outputFilename string) string {
+	if alt, ok := r.RenderOptions.AltLocales[config.Render.Locale]; ok && config.Render.Locale != "" {
+		return alt
+	}
+	if r.RenderOptions.Alt != "" {
+		return r.RenderOptions.Alt
+	}
+	if config.Render.AltFromComment {
+		if alt := r.LeadingComment(); alt != "" {
+			return alt
+		}
+	}
+	return outputFilename
+}
+
+// LeadingComment returns the text of the comment on the first line of the
+// code block, e.g. Architecture overview for // Architecture overview, or an
+// empty string if it does not start with a comment. Blank lines, and the
+// @startuml line of PlantUML diagrams, are skipped.
+func (r *Chunk) LeadingComment() string {
+	for _, line := range r.CodeBlockContent {
+		line = strings.TrimSpace(line)
+		if line == "" || r.Language == "plantuml" && strings.HasPrefix(line, "@start") {
+			continue
+		}
+		for _, v := range commentDelimiters[r.Language] {
+			start, end := v[0], v[1]
+			if !strings.HasPrefix(line, start) {
+				continue
+			}
+			if end == "" {
+				// Repeated delimiters, e.g. ## or ///, are part of the delimiter
+				return strings.TrimSpace(strings.TrimLeft(line, start[:1]))
+			}
+			// Comments spanning several lines are not used
+			text := strings.TrimPrefix(line, start)
+			if !strings.HasSuffix(text, end) {
+				return ""
+			}
+			return strings.TrimSpace(strings.TrimSuffix(text, end))
+		}
+		return ""
+	}
+	return ""
+}
+
+// HasStaleAlt checks if the alt text of the chunk's previously rendered image
+// differs from the alt text it would be rendered with, e.g. after --locale is
+// changed, so that its image line is rebuilt. Only the first image of the line
+// is checked, and the alt text of custom image templates is not parsed.
+func (r *Chunk) HasStaleAlt() bool {
+	if r.RenderedHash == "" || r.IsDataOutput() || r.HasBlockTemplate() || imageTemplate != nil {
+		return false
+	}
+	line := r.Lines[r.ImageRelativeLineIndex]
+	if _, ok := r.Format.(AsciiDocFormat); ok {
+		matches := asciiDocImageAltRegexp.FindStringSubmatch(line)
+		return matches != nil && matches[1] != escapeAsciiDocAlt(r.AltText(r.OutputFilename()))
+	}
+	// The <img> tag of a <picture> element is the fallback
+	alt := r.AltText(r.OutputFilename())
+	if r.HasFallback() {
+		alt = r.AltText(r.FallbackFilename())
+	}
+	markdownMatches := markdownImageAltRegexp.FindStringSubmatchIndex(line)
+	htmlMatches := htmlImageAltRegexp.FindStringSubmatchIndex(line)
+	switch {
+	case markdownMatches != nil && (htmlMatches == nil || markdownMatches[0] < htmlMatches[0]):
+		return line[markdownMatches[2]:markdownMatches[3]] != escapeMarkdownAlt(alt)
+	case htmlMatches != nil:
+		return line[htmlMatches[2]:htmlMatches[3]] != escapeHTMLAlt(alt)
+	default:
+		return false
+	}
+}
+
+// escapeMarkdownAlt escapes the characters that would end the alt text of a
+// markdown image.
+func escapeMarkdownAlt(alt string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(alt)
+}
+
+// escapeHTMLAlt escapes the alt text of an <img> tag.
+func escapeHTMLAlt(alt string) string {
+	return html.EscapeString(alt)
+}
+
+// escapeAsciiDocAlt quotes the alt text of an AsciiDoc image macro if it
+// contains characters that would end the attribute.
+func escapeAsciiDocAlt(alt string) string {
+	if !strings.ContainsAny(alt, `,"]=`) {
+		return alt
+	}
+	return `"` + strings.ReplaceAll(alt, `"`, `\"`) + `"`
+}
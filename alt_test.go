@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// renderImageLine renders the file with the given flags, returning its line
+// containing the image.
+func renderImageLine(t *testing.T, dir string, filePath string, flags ...string) string {
+	t.Helper()
+	args := append([]string{"render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir}, flags...)
+	err := runCommand(t, append(args, filePath)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "![") {
+			return line
+		}
+	}
+	t.Fatalf("no image line in:\n%s", b)
+	return ""
+}
+
+func TestRenderUpdatesStaleAlt(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", strings.Join([]string{
+		"```dot render{\"alt\":\"Default\",\"alt_locales\":{\"en\":\"Hello\",\"fr\":\"Bonjour\"}}",
+		"digraph { a }",
+		"```",
+	}, "\n"))
+
+	tests := []struct {
+		locale string
+		alt    string
+	}{
+		{"en", "Hello"},
+		{"fr", "Bonjour"},
+		{"fr", "Bonjour"},
+		{"", "Default"},
+	}
+	for _, tt := range tests {
+		line := renderImageLine(t, dir, filePath, "--locale", tt.locale)
+		if !strings.HasPrefix(line, "!["+tt.alt+"](") {
+			t.Errorf("--locale %q: expected the alt text %s, got %s", tt.locale, tt.alt, line)
+		}
+	}
+}
+
+func TestRenderUpdatesAltFromComment(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", strings.Join([]string{
+		"```dot render",
+		"// Architecture overview",
+		"digraph { a }",
+		"```",
+	}, "\n"))
+
+	line := renderImageLine(t, dir, filePath)
+	if !strings.HasPrefix(line, "![render-") {
+		t.Errorf("expected the filename as alt text, got %s", line)
+	}
+	line = renderImageLine(t, dir, filePath, "--alt-from-comment")
+	if !strings.HasPrefix(line, "![Architecture overview](") {
+		t.Errorf("expected the comment as alt text once --alt-from-comment is set, got %s", line)
+	}
+
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "doc.md", strings.Replace(string(b), "// Architecture overview", "// Request flow", 1))
+	line = renderImageLine(t, dir, filePath, "--alt-from-comment")
+	if !strings.HasPrefix(line, "![Request flow](") {
+		t.Errorf("expected the edited comment as alt text, got %s", line)
+	}
+	line = renderImageLine(t, dir, filePath)
+	if !strings.HasPrefix(line, "![render-") {
+		t.Errorf("expected the filename as alt text once --alt-from-comment is unset, got %s", line)
+	}
+}
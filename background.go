@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Match: #fff, #1e1e1e, #1e1e1e80, or white
+var backgroundColorRegexp = regexp.MustCompile(`^(?:#(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})|[a-zA-Z]+)$`)
+
+// validateBackground checks that the background color is a hex color or a
+// color name. Colors are passed to renderers as arguments and injected into
+// their input, so nothing else is allowed.
+func validateBackground(color string) error {
+	if color != "" && !backgroundColorRegexp.MatchString(color) {
+		return fmt.Errorf("invalid background color %s, expected a hex color like #1e1e1e or a color name", color)
+	}
+	return nil
+}
+
+// Background returns the background color to render the chunk's image with,
+// if any.
+func (r *Chunk) Background() string {
+	if r.RenderOptions.Background != "" {
+		return r.RenderOptions.Background
+	}
+	return config.Render.Background
+}
+
+// hasNativeBackground checks if the renderer of the chunk's language can
+// render a background color itself. The SVGs of other renderers have the
+// background added afterwards.
+func (r *Chunk) hasNativeBackground() bool {
+	return r.Language == "dot" || r.Language == "plantuml"
+}
+
+// addSVGBackground fills the background of an SVG with the color, with a
+// rectangle behind its content.
+func addSVGBackground(content []byte, color string) ([]byte, error) {
+	loc := svgRootTagRegexp.FindIndex(content)
+	if loc == nil {
+		return nil, errors.New("no svg element to add the background to")
+	}
+	rect := fmt.Sprintf(`<rect width="100%%" height="100%%" fill="%s"/>`, color)
+	result := append([]byte{}, content[:loc[1]]...)
+	result = append(result, rect...)
+	return append(result, content[loc[1]:]...), nil
+}
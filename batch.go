@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Printed by plantuml after each diagram rendered in a batch, to split its
+// output back into the diagrams
+const plantUMLPipeDelimiter = "__md-code-renderer-diagram-end__"
+
+var (
+	batchedOutputsMutex sync.Mutex
+	batchedOutputs      = make(map[string][]byte)
+)
+
+// takeBatchedOutput returns the output of a code block rendered ahead of time
+// in a batch, by its cache key. Each output is only returned once.
+func takeBatchedOutput(cacheKey string) ([]byte, bool) {
+	batchedOutputsMutex.Lock()
+	defer batchedOutputsMutex.Unlock()
+	content, ok := batchedOutputs[cacheKey]
+	if ok {
+		delete(batchedOutputs, cacheKey)
+	}
+	return content, ok
+}
+
+// clearBatchedOutputs discards the outputs of a file's batches that were not
+// used, e.g. because a previous code block failed to render. The batches of
+// other files rendered concurrently are kept.
+func clearBatchedOutputs(cacheKeys []string) {
+	batchedOutputsMutex.Lock()
+	defer batchedOutputsMutex.Unlock()
+	for _, v := range cacheKeys {
+		delete(batchedOutputs, v)
+	}
+}
+
+// batchRenderPlantUML renders the plantuml chunks with a single plantuml
+// process per format, since starting the JVM dominates the time to render a
+// diagram. The outputs are mapped back to their chunks by cache key, and
+// picked up when the chunks are rendered. Chunks already in the cache are
+// left out. If the batch fails, nothing is recorded, so that the chunks are
+// rendered one by one and any error is reported for the right code block.
+// The cache keys of the recorded outputs are returned.
+func batchRenderPlantUML(chunks []*Chunk) (batchedCacheKeys []string) {
+	inputs := make(map[string][]string)
+	cacheKeys := make(map[string][]string)
+	batchChunks := make(map[string][]*Chunk)
+	for _, chunk := range chunks {
+		if chunk.Language != "plantuml" {
+			continue
+		}
+		// Trim as when rendering, so that the cache key matches
+		if config.Render.TrimTrailingWhitespace {
+			chunk.TrimTrailingWhitespace()
+		}
+		input := chunk.RendererInput()
+		cacheKey := chunk.CacheKey(input)
+		if _, ok := readCache(cacheKey); ok {
+			continue
+		}
+		ext := chunk.OutputFormat()
+		if maxWidth := chunk.MaxWidth(); maxWidth > 0 && ext == "png" {
+			input = injectPlantUMLScale(input, maxWidth)
+		}
+		if background := chunk.Background(); background != "" {
+			input = injectPlantUMLDirective(input, "skinparam backgroundColor "+background)
+		}
+		// Diagrams are only delimited in a batch by their start tags
+		if !strings.HasPrefix(strings.TrimSpace(input), "@start") {
+			continue
+		}
+		inputs[ext] = append(inputs[ext], input)
+		cacheKeys[ext] = append(cacheKeys[ext], cacheKey)
+		batchChunks[ext] = append(batchChunks[ext], chunk)
+	}
+
+	for ext, v := range inputs {
+		if len(v) < 2 {
+			continue
+		}
+		// The messages of plantuml cannot be attributed to a diagram, so with
+		// --stable-order they are held with the first code block of the batch
+		first := batchChunks[ext][0]
+		if config.Render.StableOrder && first.Log == nil {
+			first.Log = &LogBuffer{}
+		}
+		warn := func(format string, args ...interface{}) {
+			if first.Log != nil {
+				fmt.Fprintf(first.Log, format, args...)
+				return
+			}
+			warnf(format, args...)
+		}
+		output, err := runShellCommandWithStderr(first.stderrOutput(), "plantuml", []string{getPlantUMLFormatFlag(ext), "-pipe", "-pipedelimitor", plantUMLPipeDelimiter}, strings.NewReader(strings.Join(v, "\n")))
+		if err != nil {
+			warn("Failed to render %d plantuml code blocks in a batch, rendering them one by one: %s\n", len(v), err)
+			continue
+		}
+		outputs := bytes.Split(output, []byte(plantUMLPipeDelimiter))
+		if len(outputs) != len(v)+1 {
+			warn("Failed to render %d plantuml code blocks in a batch, rendering them one by one: got %d diagrams\n", len(v), len(outputs)-1)
+			continue
+		}
+		batchedOutputsMutex.Lock()
+		for i, cacheKey := range cacheKeys[ext] {
+			// The delimiter is followed by a line break
+			content := outputs[i]
+			if i > 0 && bytes.HasPrefix(content, []byte("\r\n")) {
+				content = content[2:]
+			} else if i > 0 {
+				content = bytes.TrimPrefix(content, []byte("\n"))
+			}
+			batchedOutputs[cacheKey] = content
+			batchedCacheKeys = append(batchedCacheKeys, cacheKey)
+		}
+		batchedOutputsMutex.Unlock()
+	}
+	return batchedCacheKeys
+}
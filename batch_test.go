@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatchRenderPlantUMLStableOrder(t *testing.T) {
+	installStubRenderer(t, "plantuml", `cat > /dev/null
+echo "Some diagram has a warning" >&2
+printf 'one\n`+plantUMLPipeDelimiter+`\ntwo\n`+plantUMLPipeDelimiter+`\n'`)
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+	config = Config{}
+	config.Render.StableOrder = true
+	var chunks []*Chunk
+	for _, v := range []string{"a -> b", "b -> c"} {
+		chunks = append(chunks, &Chunk{
+			Language:         "plantuml",
+			CodeBlockContent: []string{"@startuml", v, "@enduml"},
+		})
+	}
+	batchedCacheKeys := batchRenderPlantUML(chunks)
+	defer clearBatchedOutputs(batchedCacheKeys)
+	if len(batchedCacheKeys) != 2 {
+		t.Fatalf("expected 2 batched outputs, got %d", len(batchedCacheKeys))
+	}
+	if chunks[0].Log == nil || !strings.Contains(string(chunks[0].Log.output), "Some diagram has a warning") {
+		t.Errorf("expected the stderr of plantuml to be held with the first code block")
+	}
+	if chunks[1].Log != nil {
+		t.Errorf("expected the stderr of plantuml to be held once, got %s", chunks[1].Log.output)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// The lines built from the block template are delimited by these comments,
+// so that they can be found and replaced on subsequent renders. The opening
+// comment stores the hash of the code block.
+const blockTemplateEndMarker = "<!-- render-template:end -->"
+
+// Match: <!-- render-template:begin hash:32455c4f renderer:dot@2.43.0 -->
+// Capture groups on the hash and the renderer.
+var blockTemplateBeginRegexp = regexp.MustCompile(`^<!-- render-template:begin hash:(` + hashPattern + `)(?: renderer:(\S+))? -->$`)
+
+// The template set with --template-file, if any
+var blockTemplate *template.Template
+
+// BlockTemplateData is the data the block template is executed with.
+type BlockTemplateData struct {
+	Filename  string // Filename of the rendered image
+	Link      string // Link to the rendered image, including the link prefix
+	Alt       string // Alt text of the image
+	Caption   string // Caption of the image, if set
+	Hash      string // Hash of the code block
+	Language  string // Language of the code block
+	Mode      string // Mode of the code block, e.g. code-collapsed
+	Code      string // Content of the code block
+	CodeBlock string // The code block with its fences, which must be included so that it is rendered again
+	Image     string // The image line that would be written without the template
+}
+
+// loadBlockTemplate parses the template file set with --template-file.
+func loadBlockTemplate(filePath string) (*template.Template, error) {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read template file")
+	}
+	t, err := template.New(filePath).Parse(string(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "parse template file")
+	}
+	return t, nil
+}
+
+// HasBlockTemplate checks if the lines of the chunk are built from the block
+// template, which replaces the templates of the modes. Only Markdown files are
+// supported.
+func (r *Chunk) HasBlockTemplate() bool {
+	_, ok := r.Format.(MarkdownFormat)
+	return blockTemplate != nil && ok
+}
+
+// BlockTemplate handles the lines built from the template set with
+// --template-file, in place of the template of the chunk's mode. The lines
+// look like:
+//
+//	<!-- render-template:begin hash:32455c4f -->
+//	...
+//	```dot render
+//	```
+//	...
+//	<!-- render-template:end -->
+//
+// Code blocks previously rendered with the template of their mode are
+// rendered again, so that their lines are replaced with the block template.
+func (m RenderTemplateManager) BlockTemplate(lines []string, codeBlockIndex int, chunk *Chunk) (err error) {
+	if chunk.RenderOptions.Mode == "external" {
+		return errors.New("external mode is not supported with a template file")
+	}
+	content, codeBlockEndIndex, _, _, err := m.collectCodeBlock(lines, codeBlockIndex)
+	if err != nil {
+		return err
+	}
+
+	// Check if rendered before, with the markers around the code block
+	beginIndex := -1
+	for i := codeBlockIndex - 1; i >= 0 && lines[i] != blockTemplateEndMarker; i-- {
+		if blockTemplateBeginRegexp.MatchString(lines[i]) {
+			beginIndex = i
+			break
+		}
+	}
+	endIndex := -1
+	for i := codeBlockEndIndex + 1; i < len(lines) && !blockTemplateBeginRegexp.MatchString(lines[i]); i++ {
+		if lines[i] == blockTemplateEndMarker {
+			endIndex = i
+			break
+		}
+	}
+	if beginIndex < 0 || endIndex < 0 {
+		err := m.parseMode(lines, codeBlockIndex, chunk)
+		if err != nil {
+			return err
+		}
+		chunk.RenderedHash = ""
+		return nil
+	}
+
+	chunk.CodeBlockContent = content
+	chunk.StartLineIndex = beginIndex
+	chunk.EndLineIndex = endIndex
+	chunk.Lines = lines[beginIndex : endIndex+1]
+	matches := blockTemplateBeginRegexp.FindStringSubmatch(lines[beginIndex])
+	chunk.RenderedHash = matches[1]
+	chunk.RenderedRenderer = matches[2]
+	// Links are looked for on the line of the image, if the template has one
+	chunk.ImageRelativeLineIndex = 0
+	fileName := chunk.OutputFilename()
+	for i, line := range chunk.Lines {
+		if strings.Contains(line, fileName) {
+			chunk.ImageRelativeLineIndex = i
+			break
+		}
+	}
+	return nil
+}
+
+// buildBlockTemplateLines executes the block template for the rendered chunk,
+// returning the lines that replace the chunk's lines.
+func (r *Chunk) buildBlockTemplateLines(fileName string, linkPrefix string) ([]string, error) {
+	// The fences are the ones the chunk was parsed from
+	fenceStart, fenceEnd := "", "```"
+	for i, line := range r.Lines {
+		if _, _, ok := r.Format.MatchRenderableBlock(line, nil); ok {
+			fenceStart = line
+			if _, _, _, v, err := (RenderTemplateManager{}).collectCodeBlock(r.Lines, i); err == nil {
+				fenceEnd = v
+			}
+			break
+		}
+	}
+	codeBlock := append(append([]string{fenceStart}, r.CodeBlockContent...), fenceEnd)
+	data := BlockTemplateData{
+		Filename:  fileName,
+		Link:      linkPrefix + fileName,
+		Alt:       r.AltText(fileName),
+		Caption:   r.RenderOptions.Caption,
+		Hash:      r.HashContent(),
+		Language:  r.Language,
+		Mode:      r.RenderOptions.Mode,
+		Code:      strings.Join(r.CodeBlockContent, "\n"),
+		CodeBlock: strings.Join(codeBlock, "\n"),
+		Image:     strings.TrimRight(r.Format.BuildImageLine(r, fileName, linkPrefix), " \t"),
+	}
+	var b strings.Builder
+	err := blockTemplate.Execute(&b, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "execute template")
+	}
+	output := strings.TrimSuffix(b.String(), "\n")
+	if !strings.Contains(output, data.CodeBlock) {
+		return nil, errors.New("template output does not include the code block, with {{.CodeBlock}}")
+	}
+
+	var renderer string
+	if config.Render.HashRendererVersion {
+		renderer = rendererMarker(r.Language)
+	}
+	beginMarker := strings.Replace(buildHashComment(r.HashContent(), renderer), "<!-- ", "<!-- render-template:begin ", 1)
+	lines := append([]string{beginMarker}, strings.Split(output, "\n")...)
+	return append(lines, blockTemplateEndMarker), nil
+}
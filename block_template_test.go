@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBlockTemplate(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	templatePath := writeTestFile(t, dir, "template.tmpl", "<figure>\n<img src=\"{{.Link}}\">\n<figcaption>{{.Caption}}</figcaption>\n</figure>\n\n{{.CodeBlock}}\n")
+	input := "# Title\n\n```dot render{\"filename\":\"graph.svg\",\"caption\":\"Request flow\"}\ndigraph { a }\n```\n\nText\n"
+	filePath := writeTestFile(t, dir, "doc.md", input)
+	args := []string{"render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--template-file", templatePath, filePath}
+
+	err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := string(b)
+	for _, v := range []string{
+		"<figcaption>Request flow</figcaption>",
+		"\n```dot render{\"filename\":\"graph.svg\",\"caption\":\"Request flow\"}\ndigraph { a }\n```\n<!-- render-template:end -->\n\nText\n",
+	} {
+		if !strings.Contains(rendered, v) {
+			t.Errorf("expected the output to contain %q:\n%s", v, rendered)
+		}
+	}
+
+	// Rendering again finds the code block between the markers, and leaves
+	// the file as is
+	err = runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != rendered {
+		t.Errorf("expected the file to be unchanged, got:\n%s", b)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CacheKey identifies the output of the renderer for the code block content,
+// which depends on the language, the output format, and the environment the
+// renderer is run with.
+func (r *Chunk) CacheKey(codeBlockContent string) string {
+	parts := []string{
+		r.Language,
+		r.OutputFormat(),
+		strings.Join(config.Render.RenderEnv, "\n"),
+		codeBlockContent,
+	}
+	// The maximum width changes how PNGs are rendered
+	if maxWidth := r.MaxWidth(); maxWidth > 0 {
+		parts = append(parts, fmt.Sprintf("maxWidth=%d", maxWidth))
+	}
+	// Layout options are passed to the renderer as arguments
+	if args := r.RenderOptions.GraphvizArgs(); len(args) > 0 {
+		parts = append(parts, strings.Join(args, " "))
+	}
+	// The background is passed to some renderers outside of their input
+	if background := r.Background(); background != "" {
+		parts = append(parts, "background="+background)
+	}
+	if config.Render.HashRendererVersion {
+		parts = append(parts, rendererMarker(r.Language))
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(parts, "\x00"))))
+}
+
+// readCache returns the cached output of a renderer, if any.
+func readCache(key string) ([]byte, bool) {
+	if config.Render.CacheDir == "" {
+		return nil, false
+	}
+	b, err := os.ReadFile(filepath.Join(config.Render.CacheDir, key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// writeCache stores the output of a renderer on a best-effort basis, logging
+// any failures. Entries are written atomically, so that runs sharing the cache
+// directory concurrently never read a partially written entry. An entry that
+// another run has already written is left as is, since it has the same
+// content.
+func writeCache(key string, content []byte) {
+	if config.Render.CacheDir == "" {
+		return
+	}
+	filePath := filepath.Join(config.Render.CacheDir, key)
+	if _, err := os.Stat(filePath); err == nil {
+		return
+	}
+	err := os.MkdirAll(config.Render.CacheDir, 0755)
+	if err == nil {
+		err = writeFileAtomic(filePath, content, 0644)
+	}
+	if err != nil {
+		warnf("Failed to write cache entry %s: %s\n", filePath, err)
+	}
+}
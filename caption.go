@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Match: *A caption*
+var captionRegexp = regexp.MustCompile(`^\*(?:[^*\\]|\\.)+\*$`)
+
+// buildCaption builds the caption paragraph written below the image: the
+// caption in italics, on a single line.
+func buildCaption(caption string) string {
+	caption = strings.Join(strings.Fields(caption), " ")
+	return "*" + strings.NewReplacer(`\`, `\\`, `*`, `\*`).Replace(caption) + "*"
+}
+
+// withCaption returns the image line followed by the chunk's caption, if it
+// has one, as a paragraph of its own.
+func (r *Chunk) withCaption(imageLine string) string {
+	if r.RenderOptions.Caption == "" {
+		return imageLine
+	}
+	return imageLine + "\n\n" + buildCaption(r.RenderOptions.Caption)
+}
+
+// updateCaption updates, adds, or removes the caption of the chunk's
+// previously rendered image line in place, for chunks whose image is
+// otherwise up to date.
+func (r *Chunk) updateCaption() {
+	if r.RenderedHash == "" || config.Render.NoRewrite || r.HasBlockTemplate() {
+		return
+	}
+	if _, ok := r.Format.(MarkdownFormat); !ok {
+		return
+	}
+	imageLine := strings.SplitN(r.Lines[r.ImageRelativeLineIndex], "\n\n", 2)[0]
+	r.Lines[r.ImageRelativeLineIndex] = r.withCaption(imageLine)
+}
+
+// checkForCaptionedImage checks for the image at the line index, like
+// checkForImage, or for the image followed by a caption paragraph, in which
+// case the caption is at the line index and the image two lines above. It
+// returns the index of the image line, or -1 if there is no image.
+func (m RenderTemplateManager) checkForCaptionedImage(chunk *Chunk, lines []string, index int) int {
+	if index < 0 || index >= len(lines) {
+		return -1
+	}
+	if m.checkForImage(chunk, lines[index], func() {}) {
+		return index
+	}
+	if index-2 >= 0 && captionRegexp.MatchString(lines[index]) && lines[index-1] == "" && m.checkForImage(chunk, lines[index-2], func() {}) {
+		return index - 2
+	}
+	return -1
+}
+
+// joinCaption joins the caption paragraph below the image, if there is one,
+// into the image line at the start of the chunk's lines, so that it is
+// replaced along with the image.
+func (m RenderTemplateManager) joinCaption(chunkLines []string, hasCaption bool) []string {
+	if !hasCaption {
+		return chunkLines
+	}
+	return append([]string{chunkLines[0] + "\n\n" + chunkLines[2]}, chunkLines[3:]...)
+}
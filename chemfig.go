@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The LaTeX document chemfig code blocks are wrapped in. The standalone class
+// crops the page to the molecule.
+const chemfigDocumentTemplate = "\\documentclass[border=2pt]{standalone}\n\\usepackage{chemfig}\n\\begin{document}\n%s\n\\end{document}\n"
+
+// chemfigLatexEnv returns the environment latex is run with, in paranoid mode,
+// so that code blocks cannot read or write files outside of the current
+// directory and dir, e.g. with \input or \openout on absolute paths, parent
+// directories or dotfiles.
+func chemfigLatexEnv(dir string) []string {
+	return []string{"openin_any=p", "openout_any=p", "TEXMFOUTPUT=" + dir}
+}
+
+// renderChemfig renders a chemfig code block, e.g. \chemfig{H-C(-[2]H)-H}, to
+// SVG. It is compiled with latex to DVI, which dvisvgm converts with the
+// glyphs as paths, so that the SVG does not depend on the TeX fonts.
+func renderChemfig(stderrOutput io.Writer, codeBlockContent string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "md-code-renderer-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "input.tex")
+	err = os.WriteFile(inputPath, []byte(fmt.Sprintf(chemfigDocumentTemplate, strings.TrimSpace(codeBlockContent))), 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "write input file")
+	}
+	// Shell escapes would let code blocks run arbitrary commands with
+	// \write18
+	output, err := runShellCommandWithEnv(stderrOutput, chemfigLatexEnv(dir), "latex", []string{"-no-shell-escape", "-interaction=nonstopmode", "-halt-on-error", "-output-directory=" + dir, inputPath}, nil)
+	if err != nil {
+		// latex reports errors on stdout, on lines starting with "!".
+		// Keep it as the stderr of the command, e.g. for --debug-dir.
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			cmdErr.Stderr = output
+		}
+		for _, line := range strings.Split(string(output), "\n") {
+			if strings.HasPrefix(line, "! ") {
+				return nil, errors.Wrap(err, "run latex: "+strings.TrimSuffix(strings.TrimPrefix(line, "! "), "."))
+			}
+		}
+		return nil, errors.Wrap(err, "run latex")
+	}
+
+	outputPath := filepath.Join(dir, "output.svg")
+	_, err = runShellCommandWithStderr(stderrOutput, "dvisvgm", []string{"--no-fonts", "--output=" + outputPath, filepath.Join(dir, "input.dvi")}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "run dvisvgm")
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read output file")
+	}
+	return content, nil
+}
@@ -3,15 +3,16 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path"
+	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/spf13/cobra"
 )
 
-var renderedImageFilenameRegexp = regexp.MustCompile(`render-.{32}\.(svg|png)`)
+var renderedImageFilenameRegexp = regexp.MustCompile(`render-(?:` + hashPattern + `)\.(?:[A-Za-z0-9_-]+\.)?(svg|png|json|html)`)
 
 func NewCleanCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -26,12 +27,15 @@ func NewCleanCmd() *cobra.Command {
 		},
 		RunE: cleanCmd,
 	}
-	cmd.Flags().StringVar(&config.Clean.ImageDir, "image-dir", "", "(required) Directory containing images")
-	cmd.MarkFlagRequired("image-dir")
+	cmd.Flags().StringVar(&config.Clean.ImageDir, "image-dir", "", "(required, unless set in the config file) Directory containing images")
 	return cmd
 }
 
 func cleanCmd(cmd *cobra.Command, args []string) error {
+	if config.Clean.ImageDir == "" {
+		return errors.New("no image directory specified, set --image-dir or clean.imageDir in the config file")
+	}
+
 	// Collect all file contents
 	var allContent string
 	for _, v := range args {
@@ -43,24 +47,28 @@ func cleanCmd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Collect files to remove
+	// Walk subdirectories too, since rendered files may be sharded
 	var filesToRemove []string
-	entries, err := os.ReadDir(config.Clean.ImageDir)
-	if err != nil {
-		return err
-	}
-	for _, v := range entries {
+	err := filepath.WalkDir(config.Clean.ImageDir, func(filePath string, v fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if v.IsDir() {
-			continue
+			return nil
 		}
 		if !renderedImageFilenameRegexp.MatchString(v.Name()) {
-			continue
+			return nil
 		}
 		// This is not efficient, since we are iterating through the
 		// contents of all files for each image being checked.
 		// Candidate for optimization later.
 		if !strings.Contains(allContent, v.Name()) {
-			filesToRemove = append(filesToRemove, path.Join(config.Clean.ImageDir, v.Name()))
+			filesToRemove = append(filesToRemove, filePath)
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Remove files
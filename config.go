@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// Config file that is loaded if --config is not specified
+const defaultConfigFile = ".md-code-renderer.json"
+
+// loadConfigFile loads the config file into the global config. Values from
+// the config file override flag defaults, but flags that were explicitly set
+// take precedence over the config file.
+func loadConfigFile(cmd *cobra.Command) error {
+	configFile := config.ConfigFile
+	if configFile == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil
+		}
+		configFile = defaultConfigFile
+	}
+
+	b, err := os.ReadFile(configFile)
+	if err != nil {
+		return errors.Wrap(err, "read config file")
+	}
+
+	// Remember the flags that were explicitly set, so that they can be
+	// reapplied over the config file.
+	changedFlags := make(map[*pflag.Flag][]string)
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			changedFlags[f] = v.GetSlice()
+		} else {
+			changedFlags[f] = []string{f.Value.String()}
+		}
+	})
+
+	err = json.Unmarshal(b, &config)
+	if err != nil {
+		return errors.Wrap(err, "unmarshal config file")
+	}
+
+	for f, values := range changedFlags {
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			err = v.Replace(values)
+		} else {
+			err = f.Value.Set(values[0])
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reapply flag --%s", f.Name)
+		}
+	}
+	return nil
+}
+
+// printConfig prints the effective render config, in the same form as the
+// config file.
+func printConfig() error {
+	b, err := json.MarshalIndent(struct {
+		Render interface{} `json:"render"`
+	}{config.Render}, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "marshal config")
+	}
+	fmt.Println(string(b))
+	return nil
+}
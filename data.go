@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Functions available in the templates of the data option
+var dataTemplateFuncs = template.FuncMap{
+	"quote": quoteDotID,
+}
+
+// expandData expands the rows of the CSV data file of a dot code block into
+// dot statements, one per row, with the template option. The first row of the
+// file is the header, and the template is executed with each row as a map
+// from the header to the value, e.g. {{quote .from}} -> {{quote .to}}. Without
+// a template, each row is an edge between its first two columns.
+func expandData(filePath string, templateText string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, "open data file")
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return "", errors.Wrap(err, "read data file")
+	}
+	if len(records) == 0 {
+		return "", errors.New("data file has no header row")
+	}
+	header := records[0]
+	if templateText == "" {
+		if len(header) < 2 {
+			return "", errors.New("data file must have two columns to build edges from without a template")
+		}
+		templateText = fmt.Sprintf("{{quote (index . %q)}} -> {{quote (index . %q)}}", header[0], header[1])
+	}
+	t, err := template.New("data").Funcs(dataTemplateFuncs).Option("missingkey=error").Parse(templateText)
+	if err != nil {
+		return "", errors.Wrap(err, "parse data template")
+	}
+
+	var statements []string
+	for i, record := range records[1:] {
+		row := make(map[string]string, len(header))
+		for j, v := range header {
+			row[v] = record[j]
+		}
+		var b strings.Builder
+		err := t.Execute(&b, row)
+		if err != nil {
+			// The header is line 1 of the data file
+			return "", errors.Wrap(err, fmt.Sprintf("execute data template on line %d", i+2))
+		}
+		statements = append(statements, b.String())
+	}
+	return strings.Join(statements, "\n"), nil
+}
+
+// quoteDotID quotes a value as a dot ID, so that values from data files with
+// spaces or quotes produce valid statements.
+func quoteDotID(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Match comments and processing instructions, e.g. <!-- Generated by graphviz
+// version 2.43.0 (0) --> or <?plantuml 1.2023.1?>, but not the XML declaration
+var svgCommentRegexp = regexp.MustCompile(`(?s)<!--.*?-->|<\?(?:[^x]|x[^m]|xm[^l]).*?\?>`)
+
+// Match the id attributes of elements
+var svgIDRegexp = regexp.MustCompile(`\sid="([^"]+)"`)
+
+// Match IDs containing a timestamp or counter, e.g. mermaid-1698765432
+var svgTimestampIDRegexp = regexp.MustCompile(`[0-9]{6,}`)
+
+// Match IDs made of random lowercase letters and digits, e.g. f1kzdekrd8l2vo
+var svgRandomIDRegexp = regexp.MustCompile(`^[a-z0-9]{10,}$`)
+
+// makeSVGDeterministic strips the content of an SVG that changes between
+// renders of the same source, with --deterministic, so that re-rendering an
+// unchanged code block yields the same bytes. Comments, which renderers use
+// for timestamps and versions, are removed, and generated IDs are renumbered
+// in order of appearance, along with the references to them.
+func makeSVGDeterministic(content []byte) []byte {
+	content = svgCommentRegexp.ReplaceAll(content, nil)
+
+	replacements := make(map[string]string)
+	for _, matches := range svgIDRegexp.FindAllSubmatch(content, -1) {
+		id := string(matches[1])
+		if _, ok := replacements[id]; ok || !isGeneratedSVGID(id) {
+			continue
+		}
+		replacements[id] = "id-" + strconv.Itoa(len(replacements))
+	}
+	if len(replacements) == 0 {
+		return content
+	}
+	// Longer IDs first, so that an ID containing another is replaced whole
+	ids := make([]string, 0, len(replacements))
+	for id := range replacements {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return len(ids[i]) > len(ids[j])
+	})
+	for _, id := range ids {
+		content = replaceSVGID(content, id, replacements[id])
+	}
+	return content
+}
+
+// isGeneratedSVGID checks if an ID looks generated by the renderer rather than
+// chosen, e.g. by the author of the diagram.
+func isGeneratedSVGID(id string) bool {
+	if svgTimestampIDRegexp.MatchString(id) {
+		return true
+	}
+	return svgRandomIDRegexp.MatchString(id) && strings.ContainsAny(id, "0123456789") && strings.ContainsAny(id, "abcdefghijklmnopqrstuvwxyz")
+}
+
+// replaceSVGID replaces the occurrences of an ID, wherever it is defined or
+// referenced, that are not part of a longer name.
+func replaceSVGID(content []byte, id string, replacement string) []byte {
+	isNameByte := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+	}
+	var b bytes.Buffer
+	start := 0
+	for offset := 0; ; {
+		i := bytes.Index(content[offset:], []byte(id))
+		if i < 0 {
+			b.Write(content[start:])
+			return b.Bytes()
+		}
+		i += offset
+		end := i + len(id)
+		if (i == 0 || !isNameByte(content[i-1])) && (end == len(content) || !isNameByte(content[end])) {
+			b.Write(content[start:i])
+			b.WriteString(replacement)
+			start = end
+			offset = end
+		} else {
+			offset = i + 1
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+)
+
+func NewDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show the changes rendering would make to markdown files, without writing them",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && config.FilesFrom == "" {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: diffCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func diffCmd(cmd *cobra.Command, args []string) error {
+	err := prepareRender()
+	if err != nil {
+		return err
+	}
+	args, err = inputFiles(args)
+	if err != nil {
+		return err
+	}
+	languages := strings.Split(config.Render.Languages, ",")
+	var changedCount int
+	for _, v := range args {
+		filePath := resolveRootPath(v)
+		diff, err := diffFile(filePath, languages, config.Render.OutputDir, linkPrefixForFile(filePath))
+		if _, ok := err.(*SourceError); ok {
+			// Already identifies the file
+			return err
+		}
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("diff file %s", v))
+		}
+		if diff != "" {
+			fmt.Print(diff)
+			changedCount++
+		}
+	}
+	if changedCount > 0 {
+		return fmt.Errorf("%d files would be changed by rendering", changedCount)
+	}
+	return nil
+}
+
+// diffFile renders the file in memory and returns a unified diff of the
+// changes to its content. The diff is empty if the file would not change.
+func diffFile(filePath string, types []string, outputDir string, linkPrefix string) (string, error) {
+	renderedFile, err := renderFile(filePath, types, outputDir, linkPrefix)
+	if err != nil {
+		return "", err
+	}
+	if renderedFile == nil {
+		return "", nil
+	}
+	if renderedFile.RenderErr != nil {
+		return "", renderedFile.RenderErr
+	}
+	if renderedFile.InputContent == renderedFile.OutputContent {
+		return "", nil
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(renderedFile.InputContent),
+		B:        difflib.SplitLines(renderedFile.OutputContent),
+		FromFile: "a/" + filePath,
+		ToFile:   "b/" + filePath,
+		Context:  3,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "compute diff")
+	}
+	return diff, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/png"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Match the opening tag of the root element of an SVG
+var svgRootTagRegexp = regexp.MustCompile(`<svg\b[^>]*>`)
+
+// Match: width="62pt", height="116px", or viewBox="0.00 0.00 62.00 116.00"
+// Capture groups on the attribute name and value.
+var svgDimensionAttributeRegexp = regexp.MustCompile(`\s(width|height|viewBox)="([^"]*)"`)
+
+// ImageDimensions are the dimensions of a rendered image, in pixels.
+type ImageDimensions struct {
+	Width  int
+	Height int
+}
+
+// ImageDimensions returns the dimensions of the chunk's rendered image, if
+// --image-dimensions is set and they can be determined.
+func (r *Chunk) ImageDimensions() *ImageDimensions {
+	return r.contentDimensions(r.RenderedContent)
+}
+
+// contentDimensions returns the dimensions of an image rendered from the
+// chunk, if --image-dimensions is set and they can be determined.
+func (r *Chunk) contentDimensions(content []byte) *ImageDimensions {
+	if !config.Render.ImageDimensions || r.IsDataOutput() {
+		return nil
+	}
+	switch r.OutputFormat() {
+	case "svg":
+		return svgDimensions(content)
+	case "png":
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(content))
+		if err != nil {
+			return nil
+		}
+		return &ImageDimensions{Width: cfg.Width, Height: cfg.Height}
+	}
+	return nil
+}
+
+// svgDimensions reads the dimensions of an SVG from the width and height
+// attributes of its root element, falling back to its viewBox. Graphviz
+// specifies the dimensions in points, which are converted to pixels.
+// Relative units such as percentages cannot be converted.
+func svgDimensions(content []byte) *ImageDimensions {
+	tag := svgRootTagRegexp.Find(content)
+	if tag == nil {
+		return nil
+	}
+	attributes := make(map[string]string)
+	for _, matches := range svgDimensionAttributeRegexp.FindAllSubmatch(tag, -1) {
+		attributes[string(matches[1])] = string(matches[2])
+	}
+	width, widthOK := parseSVGLength(attributes["width"])
+	height, heightOK := parseSVGLength(attributes["height"])
+	if widthOK && heightOK {
+		return &ImageDimensions{Width: width, Height: height}
+	}
+	fields := strings.Fields(strings.ReplaceAll(attributes["viewBox"], ",", " "))
+	if len(fields) != 4 {
+		return nil
+	}
+	w, errW := strconv.ParseFloat(fields[2], 64)
+	h, errH := strconv.ParseFloat(fields[3], 64)
+	if errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return nil
+	}
+	return &ImageDimensions{Width: int(math.Round(w)), Height: int(math.Round(h))}
+}
+
+func parseSVGLength(value string) (int, bool) {
+	scale := 1.0
+	switch {
+	case strings.HasSuffix(value, "px"):
+		value = strings.TrimSuffix(value, "px")
+	case strings.HasSuffix(value, "pt"):
+		value = strings.TrimSuffix(value, "pt")
+		scale = 4.0 / 3.0
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return int(math.Round(v * scale)), true
+}
+
+// fitSVGToWidth scales an SVG wider than maxWidth down to it, by rewriting the
+// width and height of its root element. The viewBox is kept, or added if
+// missing, so that the drawing is scaled rather than cropped.
+func fitSVGToWidth(content []byte, maxWidth int) []byte {
+	dimensions := svgDimensions(content)
+	if dimensions == nil || dimensions.Width <= maxWidth {
+		return content
+	}
+	loc := svgRootTagRegexp.FindIndex(content)
+	tag := string(content[loc[0]:loc[1]])
+	hasViewBox := false
+	tag = svgDimensionAttributeRegexp.ReplaceAllStringFunc(tag, func(match string) string {
+		if strings.HasPrefix(strings.TrimSpace(match), "viewBox") {
+			hasViewBox = true
+			return match
+		}
+		return ""
+	})
+	height := int(math.Round(float64(dimensions.Height) * float64(maxWidth) / float64(dimensions.Width)))
+	attributes := fmt.Sprintf(` width="%d" height="%d"`, maxWidth, height)
+	if !hasViewBox {
+		attributes += fmt.Sprintf(` viewBox="0 0 %d %d"`, dimensions.Width, dimensions.Height)
+	}
+	tag = "<svg" + attributes + strings.TrimPrefix(tag, "<svg")
+	return append(append(append([]byte{}, content[:loc[0]]...), tag...), content[loc[1]:]...)
+}
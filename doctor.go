@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// How to install the renderer of each language
+var rendererInstallHints = map[string]string{
+	"dot":         "install Graphviz, e.g. apt install graphviz or brew install graphviz",
+	"plantuml":    "install PlantUML, e.g. apt install plantuml or brew install plantuml",
+	"pikchr":      "build pikchr from https://pikchr.org and add it to your PATH",
+	"mscgen":      "install mscgen, e.g. apt install mscgen or brew install mscgen",
+	"math":        "install mathjax-node-cli with npm install -g mathjax-node-cli",
+	"excalidraw":  "install excalidraw-brute-export-cli with npm install -g excalidraw-brute-export-cli",
+	"erd":         "install erd from https://github.com/BurntSushi/erd, which also requires Graphviz",
+	"railroad":    "download rr from https://github.com/GuntherRademacher/rr and add a wrapper named rr to your PATH",
+	"structurizr": "install the Structurizr CLI from https://github.com/structurizr/cli and add it to your PATH as structurizr-cli, along with PlantUML",
+	"chemfig":     "install a TeX distribution with the chemfig package and dvisvgm, e.g. apt install texlive-science or brew install --cask mactex",
+	"python-plot": "install Python 3 and the plotting libraries used by the code blocks, e.g. pip install matplotlib",
+}
+
+func NewDoctorCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the renderers are installed and the output directory is writable",
+		Long:  ``,
+		Args:  cobra.NoArgs,
+		RunE:  doctorCmd,
+	}
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "Languages to check the renderers of. Comma-separated. If not specified, all supported languages are checked.")
+	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory to check that rendered images can be written to. If not specified, the current directory is checked.")
+	cmd.Flags().StringVar(&config.Render.AllowList, "allow-list", "", "Renderer binaries that are allowed to run. Comma-separated.")
+	cmd.Flags().StringVar(&config.Render.DenyList, "deny-list", "", "Renderer binaries that are not allowed to run. Comma-separated.")
+	return cmd
+}
+
+func doctorCmd(cmd *cobra.Command, args []string) error {
+	languages := supportedLanguages
+	if config.Render.Languages != "" {
+		languages = strings.Split(config.Render.Languages, ",")
+	}
+
+	var problemCount int
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, language := range languages {
+		status, detail := checkRenderer(language)
+		if status != "ok" {
+			problemCount++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", language, status, detail)
+	}
+	status, detail := checkOutputDir(resolveRootPath(config.Render.OutputDir))
+	if status != "ok" {
+		problemCount++
+	}
+	fmt.Fprintf(w, "output dir\t%s\t%s\n", status, detail)
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+	if problemCount > 0 {
+		return fmt.Errorf("found %d problems", problemCount)
+	}
+	return nil
+}
+
+// checkRenderer checks that the renderer of the language is installed and
+// allowed to run, returning its status and either its version and path, or
+// how to fix it.
+func checkRenderer(language string) (status string, detail string) {
+	command, ok := rendererVersionCommands[language]
+	if !ok {
+		return "unsupported", "supported languages: " + strings.Join(supportedLanguages, ", ")
+	}
+	binary := command[0]
+	if err := validateCommandAllowed(binary); err != nil {
+		return "not allowed", fmt.Sprintf("%s, update --allow-list or --deny-list", err)
+	}
+	binaryPath, err := exec.LookPath(binary)
+	if err != nil {
+		return "missing", fmt.Sprintf("%s not found in PATH, %s", binary, rendererInstallHints[language])
+	}
+	return "ok", fmt.Sprintf("%s, version %s", binaryPath, probeRendererVersion(language))
+}
+
+// checkOutputDir checks that rendered images can be written to the directory.
+// A directory that does not exist yet is created when rendering, so the
+// nearest existing parent is checked instead.
+func checkOutputDir(dir string) (status string, detail string) {
+	if dir == "" {
+		dir = "."
+	}
+	existingDir := dir
+	for {
+		fileInfo, err := os.Stat(existingDir)
+		if err == nil {
+			if !fileInfo.IsDir() {
+				return "not a directory", fmt.Sprintf("%s is a file, choose another --output-dir", existingDir)
+			}
+			break
+		}
+		parent := filepath.Dir(existingDir)
+		if parent == existingDir {
+			return "missing", fmt.Sprintf("%s does not exist", dir)
+		}
+		existingDir = parent
+	}
+	f, err := os.CreateTemp(existingDir, ".md-code-renderer-doctor-")
+	if err != nil {
+		return "not writable", fmt.Sprintf("%s, check the permissions of %s", err, existingDir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if existingDir != dir {
+		return "ok", fmt.Sprintf("%s (will be created)", dir)
+	}
+	return "ok", dir
+}
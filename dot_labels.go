@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Match the end of an attribute assignment to a label, e.g. `label = `, up to
+// the opening quote of the value
+var dotLabelAssignmentRegexp = regexp.MustCompile(`\b(?:label|xlabel|headlabel|taillabel)\s*=\s*$`)
+
+// wrapDotLabels wraps the quoted values of label attributes longer than width
+// characters, by inserting line breaks between words. Quoted strings, HTML
+// strings, and comments are skipped over as a whole, so that their contents
+// are never mistaken for attributes. HTML labels, and labels that already contain line
+// breaks, are left as is.
+func wrapDotLabels(content string, width int) string {
+	var b strings.Builder
+	for i := 0; i < len(content); {
+		switch {
+		case strings.HasPrefix(content[i:], "//"):
+			end := strings.Index(content[i:], "\n")
+			if end < 0 {
+				end = len(content) - i
+			}
+			b.WriteString(content[i : i+end])
+			i += end
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				end = len(content) - i
+			} else {
+				end += 4
+			}
+			b.WriteString(content[i : i+end])
+			i += end
+		case content[i] == '<':
+			// HTML labels are delimited by balanced angle brackets, and
+			// may contain quotes and slashes that are not DOT syntax
+			end := i + 1
+			for depth := 1; end < len(content) && depth > 0; end++ {
+				switch content[end] {
+				case '<':
+					depth++
+				case '>':
+					depth--
+				}
+			}
+			b.WriteString(content[i:end])
+			i = end
+		case content[i] == '"':
+			end := i + 1
+			for end < len(content) && content[end] != '"' {
+				if content[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(content) {
+				// Unterminated, leave it for the renderer to report
+				b.WriteString(content[i:])
+				return b.String()
+			}
+			value := content[i+1 : end]
+			// Only the text right before the value can be the assignment
+			start := i - 32
+			if start < 0 {
+				start = 0
+			}
+			if dotLabelAssignmentRegexp.MatchString(content[start:i]) {
+				value = wrapLabel(value, width)
+			}
+			b.WriteString(`"` + value + `"`)
+			i = end + 1
+		default:
+			b.WriteByte(content[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// wrapLabel wraps the words of a label into lines of at most width
+// characters. Words longer than the width are kept on a line of their own.
+func wrapLabel(label string, width int) string {
+	if utf8.RuneCountInString(label) <= width {
+		return label
+	}
+	for _, v := range []string{`\n`, `\l`, `\r`} {
+		if strings.Contains(label, v) {
+			return label
+		}
+	}
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(label) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, `\n`)
+}
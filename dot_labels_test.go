@@ -0,0 +1,55 @@
+package main
+
+import "testing"
+
+func TestWrapDotLabels(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "plain long label",
+			content:  `a [label="the quick brown fox jumps over the lazy dog"]`,
+			expected: `a [label="the quick\nbrown fox\njumps over\nthe lazy\ndog"]`,
+		},
+		{
+			name:     "short label",
+			content:  `a [label="short"]`,
+			expected: `a [label="short"]`,
+		},
+		{
+			name:     "label with line breaks",
+			content:  `a [label="the quick brown fox\ljumps over the lazy dog\l"]`,
+			expected: `a [label="the quick brown fox\ljumps over the lazy dog\l"]`,
+		},
+		{
+			name:     "html label",
+			content:  `a [label=<<table><tr><td title="label=the quick brown fox jumps">the quick brown fox jumps over the lazy dog</td></tr></table>>]`,
+			expected: `a [label=<<table><tr><td title="label=the quick brown fox jumps">the quick brown fox jumps over the lazy dog</td></tr></table>>]`,
+		},
+		{
+			name:     "quoted label with escaped quotes",
+			content:  `a [label="say \"hi\"" tooltip="label=the quick brown fox jumps over the lazy dog"]`,
+			expected: `a [label="say \"hi\"" tooltip="label=the quick brown fox jumps over the lazy dog"]`,
+		},
+		{
+			name:     "other attribute",
+			content:  `a [tooltip="the quick brown fox jumps over the lazy dog"]`,
+			expected: `a [tooltip="the quick brown fox jumps over the lazy dog"]`,
+		},
+		{
+			name:     "comments",
+			content:  "// label=\"the quick brown fox jumps over the lazy dog\"\n/* label=\"the quick brown fox jumps over the lazy dog\" */",
+			expected: "// label=\"the quick brown fox jumps over the lazy dog\"\n/* label=\"the quick brown fox jumps over the lazy dog\" */",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := wrapDotLabels(tt.content, 10)
+			if actual != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// outputClaim records the code block an output file is rendered from.
+type outputClaim struct {
+	location string // File and line of the code block
+	hash     string // Hash of the code block content
+}
+
+var (
+	outputClaimsMutex sync.Mutex
+	outputClaims      = make(map[string]outputClaim)
+)
+
+// claimOutputFiles checks that the output files of the file's chunks are not
+// rendered from a different code block earlier in the run, e.g. when two files
+// set the same custom filename with the same output directory, which would
+// overwrite each other. Identical code blocks may share an output file.
+func claimOutputFiles(filePath string, chunks []*Chunk, outputDir string) error {
+	outputClaimsMutex.Lock()
+	defer outputClaimsMutex.Unlock()
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		claim := outputClaim{
+			location: fmt.Sprintf("%s:%d", filePath, chunk.CodeBlockIndex+1),
+			hash:     chunk.HashContent(),
+		}
+		// Each view of a structurizr workspace is an output file of its own
+		fileNames := append([]string{chunk.OutputFilename()}, chunk.ViewFilenames()...)
+		for _, v := range fileNames {
+			outputFilePath, err := filepath.Abs(path.Join(outputDir, chunk.OutputSubdir(), v))
+			if err != nil {
+				continue
+			}
+			existing, ok := outputClaims[outputFilePath]
+			if ok && existing.hash != claim.hash {
+				return fmt.Errorf("line %d: output file %s is also rendered from %s, with different content", chunk.CodeBlockIndex+1, v, existing.location)
+			}
+			if !ok {
+				outputClaims[outputFilePath] = claim
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// The encoding of the input files set with --encoding, or nil if they are
+// UTF-8
+var inputEncoding encoding.Encoding
+
+// lookupEncoding returns the encoding with the given IANA name or alias, e.g.
+// utf-16le or latin1. UTF-8 returns nil, as the input is used as is. UTF-16
+// must be given with its byte order, so that files are written back in the
+// byte order they were read in. A byte order mark is kept as is.
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "", "utf-8", "utf8":
+		return nil, nil
+	case "utf-16", "utf16":
+		return nil, fmt.Errorf("unsupported encoding: %s, expected utf-16le or utf-16be", name)
+	}
+	e, err := ianaindex.IANA.Encoding(name)
+	if err != nil || e == nil {
+		return nil, fmt.Errorf("unsupported encoding: %s", name)
+	}
+	return e, nil
+}
+
+// decodeInput decodes the content of an input file from its encoding to UTF-8.
+func decodeInput(b []byte) (string, error) {
+	if inputEncoding == nil {
+		return string(b), nil
+	}
+	b, err := inputEncoding.NewDecoder().Bytes(b)
+	if err != nil {
+		return "", errors.Wrap(err, "decode input")
+	}
+	return string(b), nil
+}
+
+// encodeOutput encodes the content of a rewritten file back to the encoding of
+// the input. Characters that the encoding cannot represent, e.g. in a
+// generated alt text, are an error rather than being silently replaced.
+func encodeOutput(content string) ([]byte, error) {
+	if inputEncoding == nil {
+		return []byte(content), nil
+	}
+	b, err := inputEncoding.NewEncoder().Bytes([]byte(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "encode output")
+	}
+	return b, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Match the line number and message in the errors reported by each renderer.
+// Line numbers are relative to the input piped to the renderer.
+var rendererErrorLineRegexps = map[string]*regexp.Regexp{
+	// Error: <stdin>: syntax error in line 5 near 'x'
+	"dot": regexp.MustCompile(`(syntax error) in line (\d+)(.*)`),
+	// ERROR
+	// 5
+	// Syntax Error?
+	"plantuml": regexp.MustCompile(`(?m)^ERROR\s*\n(\d+)\s*\n(.*)`),
+	// Error detected at line 5: ...
+	"mscgen": regexp.MustCompile(`Error detected at line (\d+): (.*)`),
+}
+
+// SourceError is a renderer error located to a line of the input file.
+type SourceError struct {
+	FilePath string
+	Line     int
+	Message  string
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.FilePath, e.Line, e.Message)
+}
+
+// parseRendererErrorLine finds the line number and message in the stderr of
+// a renderer. The line number is relative to the rendered content.
+func parseRendererErrorLine(language string, stderr []byte) (line int, message string, ok bool) {
+	re, ok := rendererErrorLineRegexps[language]
+	if !ok {
+		return 0, "", false
+	}
+	matches := re.FindStringSubmatch(string(stderr))
+	if matches == nil {
+		return 0, "", false
+	}
+	switch language {
+	case "dot":
+		// The message surrounds the line number
+		line, _ = strconv.Atoi(matches[2])
+		message = matches[1] + matches[3]
+	default:
+		line, _ = strconv.Atoi(matches[1])
+		message = matches[2]
+	}
+	return line, strings.TrimSpace(message), line > 0
+}
+
+// LocateRenderError translates the line number reported by a failed renderer
+// to the line of the file the code block content was read from, which is
+// either the input file, the included file, or the external source file.
+// Errors without a line number are returned as is.
+func (r *Chunk) LocateRenderError(filePath string, err error) error {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return err
+	}
+	line, message, ok := parseRendererErrorLine(r.Language, cmdErr.Stderr)
+	// Preludes and included snippets shift the lines of the rendered
+	// content, and postludes add lines past the end of the code block.
+	if !ok || config.Render.Preludes[r.Language] != "" || r.IncludedBefore != "" || line > len(r.CodeBlockContent) {
+		return err
+	}
+
+	switch {
+	case r.RenderOptions.Include != "":
+		filePath = filepath.Join(r.FileDir, filepath.FromSlash(r.RenderOptions.Include))
+	case r.RenderOptions.Mode == "external" && !r.HasPendingSourceFile:
+		filePath = r.SourceFilePath
+	default:
+		line += r.CodeBlockContentIndex
+	}
+	return &SourceError{
+		FilePath: filePath,
+		Line:     line,
+		Message:  r.Language + " " + message,
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewFlattenCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "flatten",
+		Short: "Replace rendered code blocks with plain code blocks, removing the render directive and rendered images",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: flattenCmd,
+	}
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "Languages to flatten. Comma-separated. If not specified, code blocks of any language are flattened.")
+	cmd.Flags().StringVar(&config.Render.InputFormat, "input-format", "auto", "Format of the input files. Supported formats: [auto, markdown, asciidoc].")
+	cmd.Flags().StringVar(&config.Render.Directive, "directive", "render", "Keyword in the opening fence that marks a code block for rendering")
+	cmd.Flags().BoolVarP(&config.Render.Quiet, "quiet", "q", false, "Do not print flattened code blocks")
+	return cmd
+}
+
+func flattenCmd(cmd *cobra.Command, args []string) error {
+	var languages []string
+	if config.Render.Languages != "" {
+		languages = strings.Split(config.Render.Languages, ",")
+	}
+	for _, v := range args {
+		err := flattenFile(v, languages)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("flatten file %s", v))
+		}
+	}
+	return nil
+}
+
+// flattenFile replaces each renderable chunk in the file with a plain code
+// block of its content. In the external mode, the content is read back from
+// the extracted source file.
+func flattenFile(filePath string, types []string) error {
+	inputFileContent, err := readInputFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(inputFileContent, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return err
+	}
+
+	var outputLines []string
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			outputLines = append(outputLines, chunk.Lines...)
+			continue
+		}
+		outputLines = append(outputLines, format.BuildPlainBlock(chunk)...)
+		logf("[%s:%d] Flattened %s code block\n", filePath, chunk.CodeBlockIndex+1, chunk.Language)
+	}
+
+	// Write to disk if file has changed
+	outputContent := strings.Join(outputLines, "\n")
+	if inputFileContent != outputContent {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			return errors.Wrap(err, "stat file")
+		}
+		b, err := encodeOutput(outputContent)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(filePath, b, fileInfo.Mode().Perm())
+		if err != nil {
+			return errors.Wrap(err, "write file")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestFlattenFence(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		included string
+		input    string
+		expected string
+	}{
+		{
+			name:     "markdown",
+			fileName: "doc.md",
+			input:    "```dot render\ndigraph { a }\n```\n",
+			expected: "```dot\ndigraph { a }\n```\n",
+		},
+		{
+			name:     "markdown with an alias",
+			fileName: "doc.md",
+			input:    "```graphviz render\ndigraph { a }\n```\n",
+			expected: "```graphviz\ndigraph { a }\n```\n",
+		},
+		{
+			name:     "markdown with backticks",
+			fileName: "doc.md",
+			included: "// ````\ndigraph { a }\n",
+			input:    "```dot render{\"include\":\"graph.dot\"}\n```\n",
+			expected: "`````dot\n// ````\ndigraph { a }\n`````\n",
+		},
+		{
+			name:     "asciidoc with a delimiter",
+			fileName: "doc.adoc",
+			included: "// The listing delimiter\n----\ndigraph { a }\n",
+			input:    "[source,dot,render{\"include\":\"graph.dot\"}]\n----\n----\n",
+			expected: "[source,dot]\n-----\n// The listing delimiter\n----\ndigraph { a }\n-----\n",
+		},
+		{
+			name:     "asciidoc with an alias",
+			fileName: "doc.adoc",
+			input:    "[source,puml,render]\n----\n@startuml\n@enduml\n----\n",
+			expected: "[source,puml]\n----\n@startuml\n@enduml\n----\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "graph.dot", tt.included)
+			filePath := writeTestFile(t, dir, tt.fileName, tt.input)
+			err := runCommand(t, "flatten", filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, b)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// The page written by the gallery command if --gallery-file is not set
+const defaultGalleryFile = "gallery.html"
+
+// GalleryEntry is a code block displayed in the gallery.
+type GalleryEntry struct {
+	Location string   // File and line of the code block
+	Language string   // Language of the code block
+	Code     string   // Content of the code block
+	Images   []string // Data URIs of the rendered images
+	Err      error    // Why the code block failed to render, if it did
+}
+
+func NewGalleryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gallery",
+		Short: "Render the code blocks in markdown files into a single HTML page, with the images, their location and their code, without writing images or modifying the files",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && config.FilesFrom == "" {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: galleryCmd,
+	}
+	addRenderFlags(cmd)
+	cmd.Flags().StringVar(&config.Gallery.File, "gallery-file", defaultGalleryFile, "Path to write the gallery page to")
+	return cmd
+}
+
+func galleryCmd(cmd *cobra.Command, args []string) error {
+	err := prepareRender()
+	if err != nil {
+		return err
+	}
+	args, err = inputFiles(args)
+	if err != nil {
+		return err
+	}
+	languages := strings.Split(config.Render.Languages, ",")
+	var entries []GalleryEntry
+	for _, v := range args {
+		filePath := resolveRootPath(v)
+		fileEntries, err := galleryFile(filePath, languages)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("render gallery for file %s", v))
+		}
+		entries = append(entries, fileEntries...)
+	}
+	err = writeFileAtomic(config.Gallery.File, buildGalleryPage(entries), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write gallery page")
+	}
+	logf("Wrote %d code blocks to %s\n", len(entries), config.Gallery.File)
+	return nil
+}
+
+// galleryFile renders every code block in the file in memory, whether or not
+// its image is up to date. Code blocks that fail to render are shown with
+// their error, so that one broken diagram does not hide the others.
+func galleryFile(filePath string, types []string) ([]GalleryEntry, error) {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(content, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return nil, err
+	}
+	var entries []GalleryEntry
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable || chunk.IsDataOutput() {
+			continue
+		}
+		if config.Render.OnlyLanguages != "" && !listContains(config.Render.OnlyLanguages, chunk.Language) {
+			continue
+		}
+		if !chunk.IsSelectedByTags() {
+			continue
+		}
+		entry := GalleryEntry{
+			Location: fmt.Sprintf("%s:%d", filePath, chunk.CodeBlockIndex+1),
+			Language: chunk.Language,
+			Code:     strings.Join(chunk.CodeBlockContent, "\n"),
+		}
+		entry.Images, entry.Err = galleryImages(chunk)
+		if entry.Err != nil {
+			entry.Err = chunk.LocateRenderError(filePath, entry.Err)
+			warnf("[%s] Failed to render: %s\n", entry.Location, entry.Err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// galleryImages renders the chunk's images, including the views of a
+// structurizr workspace, as data URIs.
+func galleryImages(chunk *Chunk) ([]string, error) {
+	_, err := chunk.Render(config.Render.OutputDir, "")
+	if err != nil {
+		return nil, err
+	}
+	ext := chunk.OutputFormat()
+	contents := [][]byte{chunk.RenderedContent}
+	for _, v := range chunk.Views {
+		contents = append(contents, v.Content)
+	}
+	var images []string
+	for _, v := range contents {
+		uri, err := buildDataURI(v, ext)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, uri)
+	}
+	return images, nil
+}
+
+// buildGalleryPage builds a standalone HTML page displaying the images of the
+// code blocks as thumbnails, with their location and code.
+func buildGalleryPage(entries []GalleryEntry) []byte {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Gallery</title>\n")
+	b.WriteString("<style>body { font-family: sans-serif; margin: 1em; } main { display: grid; grid-template-columns: repeat(auto-fill, minmax(320px, 1fr)); gap: 1em; } figure { margin: 0; padding: 0.5em; border: 1px solid #ddd; } figure img { display: block; max-width: 100%; max-height: 240px; margin: 0 auto; } figcaption code { font-size: 0.9em; } pre { overflow: auto; background: #f6f6f6; padding: 0.5em; } .error { color: #b00; }</style>\n")
+	b.WriteString("</head>\n<body>\n")
+	fmt.Fprintf(&b, "<h1>%d code blocks</h1>\n<main>\n", len(entries))
+	for _, v := range entries {
+		b.WriteString("<figure>\n")
+		for _, image := range v.Images {
+			fmt.Fprintf(&b, "<img src=\"%s\" alt=\"%s\" loading=\"lazy\">\n", html.EscapeString(image), html.EscapeString(path.Base(v.Location)))
+		}
+		if v.Err != nil {
+			fmt.Fprintf(&b, "<p class=\"error\">%s</p>\n", html.EscapeString(v.Err.Error()))
+		}
+		fmt.Fprintf(&b, "<figcaption><code>%s</code> (%s)</figcaption>\n", html.EscapeString(v.Location), html.EscapeString(v.Language))
+		fmt.Fprintf(&b, "<details><summary>Code</summary><pre><code>%s</code></pre></details>\n", html.EscapeString(v.Code))
+		b.WriteString("</figure>\n")
+	}
+	b.WriteString("</main>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
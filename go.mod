@@ -4,5 +4,8 @@ go 1.16
 
 require (
 	github.com/pkg/errors v0.9.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v1.3.0
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/text v0.3.7
 )
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// runPostRenderHook runs the --post-render-hook command on a rendered file,
+// with the file's path as its last argument. The command is run by the shell,
+// so it may contain arguments of its own, e.g. "svgo --multipass". It is run
+// like the renderer commands, so sh must be allowed, and it is bounded by
+// --jobs and given the --render-env variables.
+func runPostRenderHook(filePath string) error {
+	_, err := runShellCommandWithStderr(io.Discard, "sh", []string{"-c", config.Render.PostRenderHook + ` "$@"`, "sh", filePath}, nil)
+	if err != nil {
+		if commandErr, ok := err.(*CommandError); ok {
+			if s := strings.TrimSpace(string(commandErr.Stderr)); s != "" {
+				return fmt.Errorf("%s: %s", commandErr.Err, s)
+			}
+			return commandErr.Err
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPostRenderHookRunsLikeRenderers(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```dot render\ndigraph { a }\n```\n")
+	otherFilePath := writeTestFile(t, dir, "other.md", "```dot render\ndigraph { b }\n```\n")
+	hookOutputPath := filepath.Join(dir, "hook.txt")
+
+	err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--allow-list", "dot", "--post-render-hook", "true", otherFilePath)
+	if err == nil || !strings.Contains(err.Error(), "not in the allow-list") {
+		t.Errorf("expected the hook to be rejected by the allow-list, got %v", err)
+	}
+
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--render-env", "HOOK_VALUE=set", "--post-render-hook", `echo "$HOOK_VALUE" >`+hookOutputPath+`; true`, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(hookOutputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(b)) != "set" {
+		t.Errorf("expected the hook to be given the render env, got %q", b)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Placeholders supported in image templates
+const (
+	imageTemplateFilename = "{filename}"
+	imageTemplateLink     = "{link}"
+	imageTemplateHash     = "{hash}"
+	imageTemplateAlt      = "{alt}"
+)
+
+// Built-in image templates for popular static site generators, by output
+// style. The markdown output style uses the markdown image syntax, and has no
+// template.
+var outputStyleTemplates = map[string]string{
+	"hugo": `{{< figure src="{link}" alt="{alt}" >}}`,
+	// MDX does not support HTML comments, so the hash is stored as an
+	// attribute instead.
+	"docusaurus": `<img src="{link}" alt="{alt}" data-hash="{hash}" />`,
+}
+
+// ImageTemplate builds and detects the line linking to a rendered image, for
+// link syntaxes other than the markdown image syntax, e.g. shortcodes of
+// static site generators.
+type ImageTemplate struct {
+	template string
+	regexp   *regexp.Regexp
+}
+
+// NewImageTemplate creates an ImageTemplate. The template may contain the
+// {filename}, {link}, {hash}, and {alt} placeholders. If pattern is empty, the
+// regexp used to detect previously rendered images is derived from the
+// template. Otherwise the pattern must contain a named capture group for
+// either the filename or the link, and optionally one for the hash.
+func NewImageTemplate(template string, pattern string) (*ImageTemplate, error) {
+	if !strings.Contains(template, imageTemplateFilename) && !strings.Contains(template, imageTemplateLink) {
+		return nil, errors.New("image template must contain {filename} or {link}")
+	}
+	if pattern == "" {
+		pattern = regexp.QuoteMeta(template)
+		pattern = strings.Replace(pattern, regexp.QuoteMeta(imageTemplateFilename), `(?P<filename>[^/"'()\s]+)`, 1)
+		pattern = strings.Replace(pattern, regexp.QuoteMeta(imageTemplateLink), `(?P<link>[^"'()\s]+)`, 1)
+		pattern = strings.Replace(pattern, regexp.QuoteMeta(imageTemplateHash), `(?P<hash>`+hashPattern+`)?`, 1)
+		// Any further occurrences of a placeholder repeat the same value
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(imageTemplateFilename), `[^/"'()\s]+`)
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(imageTemplateLink), `[^"'()\s]+`)
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(imageTemplateHash), `(?:`+hashPattern+`)?`)
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(imageTemplateAlt), `.*?`)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "compile image regexp")
+	}
+	if re.SubexpIndex("filename") < 0 && re.SubexpIndex("link") < 0 {
+		return nil, errors.New("image regexp must contain a filename or link capture group")
+	}
+	return &ImageTemplate{template: template, regexp: re}, nil
+}
+
+// HasHash returns whether the template stores the hash itself. If it does
+// not, the hash is stored in a comment next to the image instead.
+func (t *ImageTemplate) HasHash() bool {
+	return strings.Contains(t.template, imageTemplateHash)
+}
+
+// Build builds the image line.
+func (t *ImageTemplate) Build(outputFilename, linkPrefix, alt, hash string) string {
+	r := strings.NewReplacer(
+		imageTemplateFilename, outputFilename,
+		imageTemplateLink, linkPrefix+outputFilename,
+		imageTemplateHash, hash,
+		imageTemplateAlt, strings.ReplaceAll(alt, `"`, "&quot;"),
+	)
+	return r.Replace(t.template)
+}
+
+// Match detects an image in the line, returning the filename of the image
+// and the hash if the template stores it.
+func (t *ImageTemplate) Match(line string) (filename string, hash string, ok bool) {
+	matches := t.regexp.FindStringSubmatch(line)
+	if matches == nil {
+		return "", "", false
+	}
+	if idx := t.regexp.SubexpIndex("filename"); idx >= 0 {
+		filename = matches[idx]
+	} else {
+		filename = path.Base(matches[t.regexp.SubexpIndex("link")])
+	}
+	if idx := t.regexp.SubexpIndex("hash"); idx >= 0 {
+		hash = matches[idx]
+	}
+	return filename, hash, true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FileStamp identifies the version of a file by its modification time and
+// size. A file that does not exist has a size of -1.
+type FileStamp struct {
+	ModTime int64 `json:"modTime"`
+	Size    int64 `json:"size"`
+}
+
+// IncrementalState records the files that were fully rendered by previous
+// runs, along with the stamps of the files their output depends on, so that
+// files that have not changed since can be skipped without being parsed.
+type IncrementalState struct {
+	ConfigHash string                          `json:"configHash"`
+	Files      map[string]map[string]FileStamp `json:"files"`
+
+	mutex sync.Mutex
+}
+
+// The incremental state of the current batch render, if requested
+var incrementalState *IncrementalState
+
+func stampFile(filePath string) FileStamp {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return FileStamp{Size: -1}
+	}
+	return FileStamp{ModTime: fileInfo.ModTime().UnixNano(), Size: fileInfo.Size()}
+}
+
+// loadIncrementalState reads the state file. The state is discarded if it was
+// recorded with a different render config, since the config affects the
+// output of every file.
+func loadIncrementalState(filePath string) (*IncrementalState, error) {
+	b, err := json.Marshal(config.Render)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal render config")
+	}
+	state := &IncrementalState{
+		ConfigHash: fmt.Sprintf("%x", md5.Sum(b)),
+		Files:      make(map[string]map[string]FileStamp),
+	}
+	b, err = os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read incremental state")
+	}
+	var previous IncrementalState
+	err = json.Unmarshal(b, &previous)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal incremental state")
+	}
+	if previous.ConfigHash == state.ConfigHash && previous.Files != nil {
+		state.Files = previous.Files
+	}
+	return state, nil
+}
+
+// IsUnchanged checks if the file, and every file its output depends on, is
+// unchanged since the file was last fully rendered.
+func (s *IncrementalState) IsUnchanged(filePath string) bool {
+	s.mutex.Lock()
+	stamps, ok := s.Files[filePath]
+	s.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	for dependency, stamp := range stamps {
+		if stampFile(dependency) != stamp {
+			return false
+		}
+	}
+	return true
+}
+
+// Record records the stamps of the file and the files its output depends on:
+// the sidecar file, the files included by its code blocks, their data files,
+// their external source files, and their images.
+func (s *IncrementalState) Record(filePath string, renderedFile *RenderedFile, outputDir string) {
+	dependencies := []string{filePath, filePath + ".render.json"}
+	for _, chunk := range renderedFile.Chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		if chunk.RenderOptions.Include != "" {
+			dependencies = append(dependencies, filepath.Join(chunk.FileDir, filepath.FromSlash(chunk.RenderOptions.Include)))
+		}
+		for _, v := range chunk.RenderOptions.IncludeBefore {
+			dependencies = append(dependencies, filepath.Join(chunk.FileDir, filepath.FromSlash(v)))
+		}
+		if chunk.RenderOptions.Data != "" {
+			dependencies = append(dependencies, filepath.Join(chunk.FileDir, filepath.FromSlash(chunk.RenderOptions.Data)))
+		}
+		if chunk.SourceFilePath != "" {
+			dependencies = append(dependencies, chunk.SourceFilePath)
+		}
+		dependencies = append(dependencies, chunk.OutputFilePaths(outputDir)...)
+	}
+	stamps := make(map[string]FileStamp)
+	for _, v := range dependencies {
+		stamps[v] = stampFile(v)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Files[filePath] = stamps
+}
+
+// Write writes the state file.
+func (s *IncrementalState) Write(filePath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "marshal incremental state")
+	}
+	err = writeFileAtomic(filePath, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "write incremental state")
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// InputFormat handles the syntax of an input file format: how code blocks
+// marked for rendering are detected, and how rendered images are linked.
+type InputFormat interface {
+	// MatchRenderableBlock checks if the line starts a code block marked
+	// for rendering, returning its language and the raw render options. If
+	// typeLookup is nil, any language matches.
+	MatchRenderableBlock(line string, typeLookup map[string]bool) (language string, renderOptionsJSON string, ok bool)
+	// ParseChunk determines the lines in and around the code block that
+	// belong to the renderable chunk, according to its render mode.
+	ParseChunk(lines []string, codeBlockIndex int, chunk *Chunk) error
+	// BuildImageLine builds the line linking to the rendered image.
+	BuildImageLine(chunk *Chunk, fileName string, linkPrefix string) string
+	// BuildPlainBlock builds a plain code block of the chunk's content,
+	// without the render directive.
+	BuildPlainBlock(chunk *Chunk) []string
+	// splitOpeningLine splits the line opening a code block into the
+	// language as written, before resolving any alias, and the rest.
+	splitOpeningLine(line string) (language string, rest string, ok bool)
+}
+
+// Alternate names of languages in code blocks, mapped to the language they are
+// rendered as. Can be extended or overridden in the config file.
+var defaultLanguageAliases = map[string]string{
+	"graphviz": "dot",
+	"puml":     "plantuml",
+	"uml":      "plantuml",
+}
+
+// resolveLanguageAlias returns the language a code block's language is
+// rendered as, resolving any alias.
+func resolveLanguageAlias(language string) string {
+	if v, ok := config.Render.LanguageAliases[language]; ok {
+		return v
+	}
+	if v, ok := defaultLanguageAliases[language]; ok {
+		return v
+	}
+	return language
+}
+
+// inputFormatFromPath returns the input format of a file based on its
+// extension, unless overridden by --input-format.
+func inputFormatFromPath(filePath string) (InputFormat, error) {
+	format := config.Render.InputFormat
+	if format == "" || format == "auto" {
+		switch strings.ToLower(filepath.Ext(inputFilePath(filePath))) {
+		case ".adoc", ".asciidoc", ".asc":
+			format = "asciidoc"
+		default:
+			format = "markdown"
+		}
+	}
+	switch format {
+	case "markdown":
+		return MarkdownFormat{}, nil
+	case "asciidoc":
+		return AsciiDocFormat{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+}
+
+// MarkdownFormat handles markdown files, where code blocks are marked for
+// rendering in the opening fence, e.g. ```dot render
+type MarkdownFormat struct{}
+
+func (f MarkdownFormat) MatchRenderableBlock(line string, typeLookup map[string]bool) (language string, renderOptionsJSON string, ok bool) {
+	language, rest, ok := f.splitOpeningLine(line)
+	if !ok || !strings.HasPrefix(rest, config.Render.Directive) {
+		return "", "", false
+	}
+	language = resolveLanguageAlias(language)
+	if typeLookup != nil && !typeLookup[language] {
+		return "", "", false
+	}
+	return language, strings.TrimPrefix(rest, config.Render.Directive), true
+}
+
+// splitOpeningLine splits the opening fence of a code block into the language
+// as written, and the rest of the info string.
+func (f MarkdownFormat) splitOpeningLine(line string) (language string, rest string, ok bool) {
+	if !strings.HasPrefix(line, "```") {
+		return "", "", false
+	}
+	info := strings.TrimPrefix(line, "```")
+	idx := strings.Index(info, " ")
+	if idx < 0 {
+		return "", "", false
+	}
+	return info[:idx], info[idx+1:], true
+}
+
+func (f MarkdownFormat) ParseChunk(lines []string, codeBlockIndex int, chunk *Chunk) error {
+	renderTemplateManager := RenderTemplateManager{}
+	chunk.CodeBlockContentIndex = codeBlockIndex + 1
+	if blockTemplate != nil {
+		return renderTemplateManager.BlockTemplate(lines, codeBlockIndex, chunk)
+	}
+	return renderTemplateManager.parseMode(lines, codeBlockIndex, chunk)
+}
+
+func (f MarkdownFormat) BuildImageLine(chunk *Chunk, fileName string, linkPrefix string) string {
+	var hash, renderer string
+	if chunk.HasHashComment {
+		hash = chunk.CommentHash()
+	}
+	if config.Render.HashRendererVersion {
+		renderer = rendererMarker(chunk.Language)
+	}
+	switch {
+	case chunk.IsDataOutput():
+		link := buildDataLink(fileName, linkPrefix)
+		if hash != "" || renderer != "" {
+			link = link + " " + buildHashComment(hash, renderer)
+		}
+		return link
+	case imageTemplate != nil:
+		image := imageTemplate.Build(fileName, linkPrefix, chunk.AltText(fileName), hash)
+		if imageTemplate.HasHash() {
+			hash = ""
+		}
+		if hash != "" || renderer != "" {
+			image = image + " " + buildHashComment(hash, renderer)
+		}
+		return image
+	case config.Render.HashStyle == "attribute":
+		image := buildHTMLImage(fileName, linkPrefix, chunk.AltText(fileName), hash, chunk.ImageDimensions())
+		if chunk.HasFallback() {
+			image = buildPictureImage(fileName, chunk.FallbackFilename(), linkPrefix, chunk.AltText(chunk.FallbackFilename()), hash, chunk.ImageDimensions())
+		}
+		if chunk.HasViewer() {
+			image = buildViewerLink(image, chunk.ViewerFilename(), linkPrefix)
+		}
+		image += chunk.buildViewImages(linkPrefix)
+		if renderer != "" {
+			image = image + " " + buildHashComment("", renderer)
+		}
+		return image
+	default:
+		// Markdown images cannot specify their dimensions
+		image := buildMarkdownImage(fileName, linkPrefix, chunk.AltText(fileName))
+		if dimensions := chunk.ImageDimensions(); dimensions != nil {
+			image = buildHTMLImage(fileName, linkPrefix, chunk.AltText(fileName), "", dimensions)
+		}
+		if chunk.HasFallback() {
+			image = buildPictureImage(fileName, chunk.FallbackFilename(), linkPrefix, chunk.AltText(chunk.FallbackFilename()), "", chunk.ImageDimensions())
+		}
+		if chunk.HasViewer() {
+			image = buildViewerLink(image, chunk.ViewerFilename(), linkPrefix)
+		}
+		image += chunk.buildViewImages(linkPrefix)
+		if hash != "" || renderer != "" {
+			image = image + " " + buildHashComment(hash, renderer)
+		}
+		return image
+	}
+}
+
+// BuildPlainBlock builds a fenced code block in the language as written in the
+// original fence. The fence is longer than any run of backticks in the
+// content, e.g. of an included file with code blocks of its own, so that the
+// content cannot close it.
+func (f MarkdownFormat) BuildPlainBlock(chunk *Chunk) []string {
+	fenceLength := 3
+	for _, line := range chunk.CodeBlockContent {
+		run := 0
+		for _, c := range line {
+			if c != '`' {
+				run = 0
+				continue
+			}
+			run++
+			if run >= fenceLength {
+				fenceLength = run + 1
+			}
+		}
+	}
+	fence := strings.Repeat("`", fenceLength)
+	lines := []string{fence + chunk.WrittenLanguage}
+	lines = append(lines, chunk.CodeBlockContent...)
+	return append(lines, fence)
+}
+
+// Match: image::/optional/path/to/render-db6d08bb022ed12c2cc74d86d7a4707d.svg[alt text,hash=db6d08bb]
+// Capture groups on the target and the attributes.
+var asciidocImageRegexp = regexp.MustCompile(`^image::(.+?)\[(.*)\]$`)
+
+// Match: hash=db6d08bb
+// Capture group on the hash.
+var asciidocHashAttributeRegexp = regexp.MustCompile(`(?:^|,)hash=(` + hashPattern + `)(?:,|$)`)
+
+// Match: renderer=dot@2.43.0
+// Capture group on the renderer marker.
+var asciidocRendererAttributeRegexp = regexp.MustCompile(`(?:^|,)renderer=([^,]+)(?:,|$)`)
+
+// AsciiDocFormat handles AsciiDoc files, where listing blocks are marked for
+// rendering in the block attributes, e.g. [source,dot,render]. Only the
+// normal mode is supported.
+type AsciiDocFormat struct{}
+
+func (f AsciiDocFormat) MatchRenderableBlock(line string, typeLookup map[string]bool) (language string, renderOptionsJSON string, ok bool) {
+	language, rest, ok := f.splitOpeningLine(line)
+	if !ok || !strings.HasPrefix(rest, config.Render.Directive) {
+		return "", "", false
+	}
+	language = resolveLanguageAlias(language)
+	if typeLookup != nil && !typeLookup[language] {
+		return "", "", false
+	}
+	return language, strings.TrimPrefix(rest, config.Render.Directive), true
+}
+
+// splitOpeningLine splits the attribute list of a source block into the
+// language as written, and the remaining attributes.
+func (f AsciiDocFormat) splitOpeningLine(line string) (language string, rest string, ok bool) {
+	if !strings.HasPrefix(line, "[source,") || !strings.HasSuffix(line, "]") {
+		return "", "", false
+	}
+	attributes := strings.TrimSuffix(strings.TrimPrefix(line, "[source,"), "]")
+	idx := strings.Index(attributes, ",")
+	if idx < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(attributes[:idx]), strings.TrimSpace(attributes[idx+1:]), true
+}
+
+// ParseChunk handles the template for the "normal" mode. The template looks
+// like:
+//
+//	image::render-{hash}.svg[]
+//
+//	[source,dot,render]
+//	----
+//	----
+func (f AsciiDocFormat) ParseChunk(lines []string, codeBlockIndex int, chunk *Chunk) error {
+	if blockTemplate != nil {
+		return errors.New("template file is not supported for asciidoc")
+	}
+	if chunk.RenderOptions.Mode != "normal" {
+		return fmt.Errorf("unsupported mode for asciidoc: %s", chunk.RenderOptions.Mode)
+	}
+	if chunk.IsDataOutput() {
+		return errors.New("unsupported format for asciidoc: json")
+	}
+	if chunk.RenderOptions.Caption != "" {
+		return errors.New("caption is not supported for asciidoc")
+	}
+
+	// The listing block is delimited by a line of at least 4 dashes,
+	// immediately following the block attributes.
+	if codeBlockIndex+1 >= len(lines) || !isAsciiDocListingDelimiter(lines[codeBlockIndex+1]) {
+		return errors.New("listing block delimiter not found")
+	}
+	delimiter := lines[codeBlockIndex+1]
+	var content []string
+	codeBlockEndIndex := -1
+	for i := codeBlockIndex + 2; i < len(lines); i++ {
+		if lines[i] == delimiter {
+			codeBlockEndIndex = i
+			break
+		}
+		content = append(content, lines[i])
+	}
+	if codeBlockEndIndex < 0 {
+		return errors.New("listing block is unterminated")
+	}
+	chunk.CodeBlockContent = content
+	chunk.CodeBlockContentIndex = codeBlockIndex + 2
+	chunk.StartLineIndex = codeBlockIndex
+	chunk.EndLineIndex = codeBlockEndIndex
+
+	// Check 2 lines above if the image has been rendered before
+	var isRenderedBefore bool
+	for i := 1; i <= 2 && codeBlockIndex-i >= 0; i++ {
+		idx := codeBlockIndex - i
+		if f.checkForImage(chunk, lines[idx]) {
+			chunk.StartLineIndex = idx
+			chunk.ImageRelativeLineIndex = 0
+			isRenderedBefore = true
+			break
+		}
+	}
+
+	// Render the template into the chunk. Image will be replaced later.
+	if !isRenderedBefore {
+		chunk.Lines = append([]string{"// image here", ""}, lines[codeBlockIndex:codeBlockEndIndex+1]...)
+		chunk.ImageRelativeLineIndex = 0
+		chunk.RenderedHash = ""
+	} else {
+		chunk.Lines = lines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+	}
+	return nil
+}
+
+func (f AsciiDocFormat) checkForImage(chunk *Chunk, line string) (imageExists bool) {
+	matches := asciidocImageRegexp.FindStringSubmatch(line)
+	if len(matches) != 3 {
+		return false
+	}
+	rendererMatches := asciidocRendererAttributeRegexp.FindStringSubmatch(matches[2])
+	if len(rendererMatches) == 2 {
+		chunk.RenderedRenderer = rendererMatches[1]
+	}
+	if chunk.RenderOptions.Filename != "" {
+		hashMatches := asciidocHashAttributeRegexp.FindStringSubmatch(matches[2])
+		if len(hashMatches) == 2 {
+			chunk.RenderedHash = hashMatches[1]
+		}
+		return true
+	}
+	filenameMatches := renderedFilenameRegexp.FindStringSubmatch(path.Base(matches[1]))
+	if len(filenameMatches) == 2 {
+		chunk.RenderedHash = filenameMatches[1]
+		return true
+	}
+	return false
+}
+
+// BuildImageLine builds a block image macro. Since AsciiDoc has no inline
+// comments, the hash of custom filenames is stored as an attribute, which is
+// ignored by AsciiDoc processors.
+func (f AsciiDocFormat) BuildImageLine(chunk *Chunk, fileName string, linkPrefix string) string {
+	attributes := escapeAsciiDocAlt(chunk.AltText(fileName))
+	if dimensions := chunk.ImageDimensions(); dimensions != nil {
+		attributes += fmt.Sprintf(",width=%d,height=%d", dimensions.Width, dimensions.Height)
+	}
+	if chunk.HasHashComment {
+		attributes += ",hash=" + chunk.CommentHash()
+	}
+	if config.Render.HashRendererVersion {
+		attributes += ",renderer=" + rendererMarker(chunk.Language)
+	}
+	return fmt.Sprintf("image::%s[%s]", linkPrefix+fileName, attributes)
+}
+
+// BuildPlainBlock builds a source block in the language as written in the
+// original block. The delimiter is longer than any line of the content that
+// could be read as one.
+func (f AsciiDocFormat) BuildPlainBlock(chunk *Chunk) []string {
+	delimiter := "----"
+	for _, line := range chunk.CodeBlockContent {
+		if isAsciiDocListingDelimiter(line) && len(line) >= len(delimiter) {
+			delimiter = line + "-"
+		}
+	}
+	lines := []string{"[source," + chunk.WrittenLanguage + "]", delimiter}
+	lines = append(lines, chunk.CodeBlockContent...)
+	return append(lines, delimiter)
+}
+
+func isAsciiDocListingDelimiter(line string) bool {
+	return len(line) >= 4 && strings.Trim(line, "-") == ""
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// Returned when a render was not started because of an interrupt
+var errInterrupted = errors.New("interrupted")
+
+// Set once an interrupt has been received
+var interrupted int32
+
+// handleInterrupts stops new renders from starting on SIGINT or SIGTERM, so
+// that the file being processed either finishes or is rolled back, leaving
+// files in a consistent state. A second signal exits immediately.
+func handleInterrupts() (stop func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if atomic.LoadInt32(&interrupted) == 1 {
+					os.Exit(130)
+				}
+				atomic.StoreInt32(&interrupted, 1)
+				warnf("Interrupted, waiting for in-flight renders to finish. Interrupt again to exit immediately.\n")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func isInterrupted() bool {
+	return atomic.LoadInt32(&interrupted) == 1
+}
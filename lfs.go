@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The number of bytes git checks for a NUL byte to detect binary files
+const binaryDetectionBytes = 8000
+
+// The lines delimiting the generated section of a .gitattributes file
+const (
+	gitattributesBeginMarker = "# BEGIN md-code-renderer"
+	gitattributesEndMarker   = "# END md-code-renderer"
+)
+
+// The files written by the current batch render, and whether they are binary,
+// if requested with --lfs-report or --gitattributes
+var generatedFiles = make(map[string]bool)
+
+// isGeneratedFileReportEnabled checks if the files written by the render are
+// recorded, to be reported once it is done.
+func isGeneratedFileReportEnabled() bool {
+	return config.Render.LFSReport || config.Render.Gitattributes != ""
+}
+
+// GeneratedFilePaths returns the paths of the files generated from the file's
+// code blocks, whether or not they were written in this run, including the
+// extracted source files of the external mode.
+func (f *RenderedFile) GeneratedFilePaths(outputDir string) []string {
+	var filePaths []string
+	for _, chunk := range f.Chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		filePaths = append(filePaths, chunk.OutputFilePaths(outputDir)...)
+		if chunk.SourceFilePath != "" {
+			filePaths = append(filePaths, chunk.SourceFilePath)
+		}
+	}
+	return filePaths
+}
+
+// recordGeneratedFiles records the files generated for a file, and whether
+// they are binary. Files are classified the way git does, by looking for a NUL
+// byte in their first few kilobytes, e.g. PNG images are binary and SVG
+// images, JSON and HTML are text. Files that do not exist, e.g. because their
+// code block failed to render, are left out.
+func recordGeneratedFiles(filePaths []string) error {
+	for _, v := range filePaths {
+		b, err := os.ReadFile(v)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "read generated file")
+		}
+		if len(b) > binaryDetectionBytes {
+			b = b[:binaryDetectionBytes]
+		}
+		generatedFiles[v] = bytes.IndexByte(b, 0) >= 0
+	}
+	return nil
+}
+
+// GitattributesPattern is a pattern matching generated files with the same
+// directory and extension.
+type GitattributesPattern struct {
+	Dir      string
+	Ext      string
+	IsBinary bool
+}
+
+// gitattributesPatterns groups the generated files by directory and
+// extension. A group is binary if any of its files is, so that a pattern never
+// stores a binary file outside of LFS.
+func gitattributesPatterns() []GitattributesPattern {
+	isBinary := make(map[GitattributesPattern]bool)
+	for filePath, v := range generatedFiles {
+		pattern := GitattributesPattern{Dir: path.Dir(filepath.ToSlash(filePath)), Ext: path.Ext(filePath)}
+		isBinary[pattern] = isBinary[pattern] || v
+	}
+	patterns := make([]GitattributesPattern, 0, len(isBinary))
+	for k, v := range isBinary {
+		k.IsBinary = v
+		patterns = append(patterns, k)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if patterns[i].Dir != patterns[j].Dir {
+			return patterns[i].Dir < patterns[j].Dir
+		}
+		return patterns[i].Ext < patterns[j].Ext
+	})
+	return patterns
+}
+
+// buildGitattributes builds a .gitattributes snippet storing the binary
+// generated files in LFS, and the text ones as regular text, even if a broader
+// pattern tracks them in LFS, so that their changes can be diffed and
+// reviewed. Patterns are relative to baseDir, the directory of the
+// .gitattributes file, and files outside of it are left out.
+func buildGitattributes(baseDir string) string {
+	var b strings.Builder
+	isWarned := make(map[string]bool)
+	for _, v := range gitattributesPatterns() {
+		dir, err := filepath.Rel(baseDir, filepath.FromSlash(v.Dir))
+		if err != nil {
+			dir = v.Dir
+		}
+		dir = filepath.ToSlash(dir)
+		if dir == ".." || strings.HasPrefix(dir, "../") {
+			// A .gitattributes file only applies to its own directory
+			if !isWarned[v.Dir] {
+				warnf("Generated files in %s are outside of %s, and not matched by the .gitattributes\n", v.Dir, baseDir)
+				isWarned[v.Dir] = true
+			}
+			continue
+		}
+		pattern := "*" + v.Ext
+		if dir != "." {
+			pattern = dir + "/" + pattern
+		}
+		// Spaces are not allowed in patterns, but can be matched with [ ]
+		pattern = strings.ReplaceAll(pattern, " ", "[ ]")
+		if v.IsBinary {
+			b.WriteString(pattern + " filter=lfs diff=lfs merge=lfs -text\n")
+		} else {
+			b.WriteString(pattern + " !filter !diff !merge text\n")
+		}
+	}
+	return b.String()
+}
+
+// printLFSReport prints which generated files are binary and which are text,
+// followed by a suggested .gitattributes snippet for the current directory.
+func printLFSReport() {
+	filePaths := make([]string, 0, len(generatedFiles))
+	for k := range generatedFiles {
+		filePaths = append(filePaths, k)
+	}
+	sort.Strings(filePaths)
+	binaryCount := 0
+	for _, v := range filePaths {
+		kind := "text"
+		if generatedFiles[v] {
+			kind = "binary"
+			binaryCount++
+		}
+		logf("%-6s  %s\n", kind, v)
+	}
+	logf("%d generated files: %d binary, %d text\n", len(filePaths), binaryCount, len(filePaths)-binaryCount)
+	if len(filePaths) > 0 {
+		logf("\nSuggested .gitattributes:\n%s", buildGitattributes("."))
+	}
+}
+
+// replaceGitattributesSection replaces the generated section of a
+// .gitattributes file with section, keeping the lines outside of it. The
+// section is appended if the file has none.
+func replaceGitattributesSection(content string, section string) string {
+	generated := gitattributesBeginMarker + "\n" + section + gitattributesEndMarker + "\n"
+	begin := strings.Index(content, gitattributesBeginMarker+"\n")
+	if begin >= 0 {
+		end := strings.Index(content[begin:], gitattributesEndMarker)
+		if end >= 0 {
+			rest := content[begin+end+len(gitattributesEndMarker):]
+			rest = strings.TrimPrefix(rest, "\n")
+			return content[:begin] + generated + rest
+		}
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + generated
+}
+
+// writeGitattributes writes the suggested .gitattributes snippet to the
+// generated section of a file, with patterns relative to its directory. The
+// rest of the file is left as is.
+func writeGitattributes(filePath string) error {
+	b, err := os.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "read gitattributes")
+	}
+	content := replaceGitattributesSection(string(b), buildGitattributes(filepath.Dir(filePath)))
+	err = writeFileAtomic(filePath, []byte(content), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write gitattributes")
+	}
+	logf("Wrote %s\n", filePath)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitattributesKeepsUnchangedFilesAndOtherLines(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```dot render\ndigraph { a }\n```\n")
+	gitattributesPath := writeTestFile(t, dir, ".gitattributes", "*.psd filter=lfs diff=lfs merge=lfs -text\n")
+
+	// The second run has nothing to render, and keeps the patterns of the
+	// images rendered by the first one
+	for i := 0; i < 2; i++ {
+		err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--gitattributes", gitattributesPath, filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(gitattributesPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := strings.Join([]string{
+			"*.psd filter=lfs diff=lfs merge=lfs -text",
+			gitattributesBeginMarker,
+			"*.svg !filter !diff !merge text",
+			gitattributesEndMarker,
+			"",
+		}, "\n")
+		if string(b) != expected {
+			t.Errorf("run %d: expected:\n%s\ngot:\n%s", i+1, expected, b)
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "render-*.svg"))
+	if len(matches) != 1 {
+		t.Errorf("expected one image, found %v", matches)
+	}
+}
+
+func TestReplaceGitattributesSection(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"empty", "", "# BEGIN md-code-renderer\n*.png lfs\n# END md-code-renderer\n"},
+		{"no section", "*.psd lfs", "*.psd lfs\n# BEGIN md-code-renderer\n*.png lfs\n# END md-code-renderer\n"},
+		{
+			"section",
+			"*.psd lfs\n# BEGIN md-code-renderer\n*.svg text\n# END md-code-renderer\n*.ai lfs\n",
+			"*.psd lfs\n# BEGIN md-code-renderer\n*.png lfs\n# END md-code-renderer\n*.ai lfs\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := replaceGitattributesSection(tt.content, "*.png lfs\n")
+			if actual != tt.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Match the links of image lines, after the start of the link
+var imageLinkRegexp = regexp.MustCompile(imageLinkStartPattern + `([^\s()<>\[\]"']+)`)
+
+// The number of broken links found with --verify-links
+var brokenLinkCount int
+
+// verifyLinks checks, with --verify-links, that the links to rendered files in
+// the output content of the file resolve to existing files, the way a browser
+// viewing the file would resolve them. Relative links are resolved from the
+// file's directory, and absolute paths from the project root. Links to other
+// hosts cannot be checked. Broken links are reported with their location.
+func verifyLinks(filePath string, renderedFile *RenderedFile) {
+	stems := make(map[string]bool)
+	for _, chunk := range renderedFile.Chunks {
+		if chunk.IsRenderable {
+			fileName := path.Base(chunk.OutputFilename())
+			stems[strings.TrimSuffix(fileName, path.Ext(fileName))] = true
+		}
+	}
+	root := config.Render.Root
+	if root == "" {
+		root = "."
+	}
+	for i, line := range strings.Split(renderedFile.OutputContent, "\n") {
+		for _, matches := range imageLinkRegexp.FindAllStringSubmatch(line, -1) {
+			link := matches[2]
+			if i := strings.IndexAny(link, "?#"); i >= 0 {
+				link = link[:i]
+			}
+			if strings.Contains(link, "://") || strings.HasPrefix(link, "data:") {
+				continue
+			}
+			fileName := path.Base(link)
+			if !renderedFilenameRegexp.MatchString(fileName) && !stems[strings.SplitN(fileName, ".", 2)[0]] {
+				continue
+			}
+			linkPath := link
+			if unescaped, err := url.PathUnescape(link); err == nil {
+				linkPath = unescaped
+			}
+			if path.IsAbs(linkPath) {
+				linkPath = filepath.Join(root, filepath.FromSlash(linkPath))
+			} else {
+				linkPath = filepath.Join(inputFileDir(filePath), filepath.FromSlash(linkPath))
+			}
+			if _, err := os.Stat(linkPath); err != nil {
+				warnf("[%s:%d] Broken link %s: %s does not exist\n", filePath, i+1, link, linkPath)
+				brokenLinkCount++
+			}
+		}
+	}
+}
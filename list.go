@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// ListEntry describes a code block marked for rendering.
+type ListEntry struct {
+	FilePath string
+	Line     int
+	Language string
+	Mode     string
+	Hash     string // Short hash of the code block's current content
+	Status   string // Status: rendered, stale, not rendered
+}
+
+func NewListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the code blocks marked for rendering in markdown files, and whether their images are up to date",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: listCmd,
+	}
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "Languages to list. Comma-separated. If not specified, code blocks of any language are listed.")
+	cmd.Flags().StringVar(&config.Render.InputFormat, "input-format", "auto", "Format of the input files. Supported formats: [auto, markdown, asciidoc].")
+	cmd.Flags().StringVar(&config.Render.Directive, "directive", "render", "Keyword in the opening fence that marks a code block for rendering")
+	cmd.Flags().StringVar(&config.List.Format, "format", "text", "Output format. Supported formats: [text, markdown]. The markdown format prints a table.")
+	return cmd
+}
+
+func listCmd(cmd *cobra.Command, args []string) error {
+	switch config.List.Format {
+	case "text", "markdown":
+	default:
+		return fmt.Errorf("unsupported format: %s", config.List.Format)
+	}
+	var languages []string
+	if config.Render.Languages != "" {
+		languages = strings.Split(config.Render.Languages, ",")
+	}
+	var entries []ListEntry
+	for _, v := range args {
+		fileEntries, err := listFile(v, languages)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("list file %s", v))
+		}
+		entries = append(entries, fileEntries...)
+	}
+
+	if config.List.Format == "markdown" {
+		fmt.Println("| File | Line | Language | Mode | Hash | Status |")
+		fmt.Println("| --- | --- | --- | --- | --- | --- |")
+		for _, v := range entries {
+			fmt.Printf("| %s | %d | %s | %s | %s | %s |\n", v.FilePath, v.Line, v.Language, v.Mode, v.Hash, v.Status)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, v := range entries {
+		fmt.Fprintf(w, "%s:%d\t%s\t%s\t%s\t%s\n", v.FilePath, v.Line, v.Language, v.Mode, v.Hash, v.Status)
+	}
+	return w.Flush()
+}
+
+// listFile returns an entry for each renderable chunk in the file.
+func listFile(filePath string, types []string) ([]ListEntry, error) {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(content, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ListEntry
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		status := "rendered"
+		if chunk.RenderedHash == "" {
+			status = "not rendered"
+		} else if chunk.ShouldRender(config.Render.OutputDir) {
+			status = "stale"
+		}
+		entries = append(entries, ListEntry{
+			FilePath: filePath,
+			Line:     chunk.CodeBlockIndex + 1,
+			Language: chunk.Language,
+			Mode:     chunk.RenderOptions.Mode,
+			Hash:     chunk.HashContent()[:8],
+			Status:   status,
+		})
+	}
+	return entries, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// The lockfile used with --frozen if --lockfile is not set
+const defaultLockfile = "render.lock"
+
+// LockEntry records a code block by its hash, and the image it is rendered to.
+type LockEntry struct {
+	Hash  string `json:"hash"`
+	Image string `json:"image"`
+}
+
+// Lockfile records the code blocks of each file, so that with --frozen,
+// rendering fails if a code block changed without the lockfile being updated.
+type Lockfile struct {
+	Files map[string][]LockEntry `json:"files"`
+
+	mutex sync.Mutex
+}
+
+// The lockfile of the current batch render, if requested
+var lockfile *Lockfile
+
+// loadLockfile reads the lockfile. A lockfile that does not exist is empty,
+// unless it is required with --frozen.
+func loadLockfile(filePath string) (*Lockfile, error) {
+	l := &Lockfile{Files: make(map[string][]LockEntry)}
+	b, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) && !config.Render.Frozen {
+		return l, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read lockfile")
+	}
+	err = json.Unmarshal(b, l)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal lockfile")
+	}
+	if l.Files == nil {
+		l.Files = make(map[string][]LockEntry)
+	}
+	return l, nil
+}
+
+// lockEntries returns the entries of the file's code blocks.
+func lockEntries(chunks []*Chunk, outputDir string) []LockEntry {
+	var entries []LockEntry
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		entries = append(entries, LockEntry{
+			Hash:  chunk.HashContent(),
+			Image: path.Join(outputDir, chunk.OutputSubdir(), chunk.OutputFilename()),
+		})
+	}
+	return entries
+}
+
+// Check checks that the file's code blocks match the lockfile, so that only
+// the images recorded in it are rendered.
+func (l *Lockfile) Check(filePath string, chunks []*Chunk, outputDir string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	unmatched := make(map[LockEntry]int)
+	for _, v := range l.Files[filePath] {
+		unmatched[v]++
+	}
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		entry := lockEntries([]*Chunk{chunk}, outputDir)[0]
+		if unmatched[entry] == 0 {
+			return fmt.Errorf("line %d: code block does not match the lockfile, render without --frozen to update it", chunk.CodeBlockIndex+1)
+		}
+		unmatched[entry]--
+	}
+	for _, v := range l.Files[filePath] {
+		if unmatched[v] > 0 {
+			return fmt.Errorf("lockfile lists a code block rendered to %s that is no longer in the file, render without --frozen to update it", v.Image)
+		}
+	}
+	return nil
+}
+
+// Record records the file's code blocks, replacing the ones it was loaded
+// with.
+func (l *Lockfile) Record(filePath string, chunks []*Chunk, outputDir string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.Files[filePath] = lockEntries(chunks, outputDir)
+}
+
+// Write writes the lockfile. Files that were not recorded in this run, e.g.
+// because they were not given as input, were unchanged, or failed to render,
+// keep the entries the lockfile was loaded with. Files recorded without code
+// blocks are left out.
+func (l *Lockfile) Write(filePath string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	files := make(map[string][]LockEntry)
+	for name, entries := range l.Files {
+		if len(entries) > 0 {
+			files[name] = entries
+		}
+	}
+	b, err := json.MarshalIndent(&Lockfile{Files: files}, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "marshal lockfile")
+	}
+	err = writeFileAtomic(filePath, append(b, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write lockfile")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLockfileKeepsEntriesOfFilesNotRendered(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	lockfilePath := filepath.Join(dir, "render.lock")
+	first := writeTestFile(t, dir, "first.md", "```dot render\ndigraph { a }\n```\n")
+	second := writeTestFile(t, dir, "second.md", "```dot render\ndigraph { b }\n```\n")
+	render := func(args ...string) error {
+		return runCommand(t, append([]string{"render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--lockfile", lockfilePath}, args...)...)
+	}
+
+	err := render(first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Render the first file alone, then change the second so that it fails
+	// to render, without failing the render
+	err = render(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "second.md", "```dot render\ndigraph { c }\n```\n")
+	installStubRenderer(t, "dot", `cat >/dev/null; exit 1`)
+	err = render("--warn-on-error", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := loadLockfile(lockfilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{first, second} {
+		if len(l.Files[v]) != 1 {
+			t.Errorf("expected the entry of %s to be kept, got %v", v, l.Files)
+		}
+	}
+}
@@ -3,19 +3,111 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 )
 
 type Config struct {
+	ConfigFile  string `json:"-"` // Path to the config file
+	PrintConfig bool   `json:"-"` // Print the effective config instead of rendering
+	FilesFrom   string `json:"-"` // Path to a file listing the input files, one per line
+
 	Clean struct {
-		ImageDir string
-	}
+		ImageDir string `json:"imageDir"`
+	} `json:"clean"`
+	List struct {
+		Format string `json:"format"` // Output format: text, markdown
+	} `json:"list"`
+	Stats struct {
+		Top int `json:"top"` // Number of files with the most diagrams to report
+	} `json:"stats"`
+	Gallery struct {
+		File string `json:"file"` // Path to write the gallery page to
+	} `json:"gallery"`
 	Render struct {
-		OutputDir  string // Directory to output rendered files to
-		Languages  string // Languages to render, comma separated
-		LinkPrefix string // Prefix to use when linking to rendered files
-	}
+		OutputDir     string `json:"outputDir"`     // Directory to output rendered files to
+		Languages     string `json:"languages"`     // Languages to render, comma separated
+		OnlyLanguages string `json:"onlyLanguages"` // Subset of the languages to render on this run, comma separated
+		Tags          string `json:"tags"`          // Tags of the code blocks to render on this run, comma separated
+		Untagged      string `json:"untagged"`      // Whether code blocks without tags are rendered with Tags set: include, exclude
+		LinkPrefix    string `json:"linkPrefix"`    // Prefix to use when linking to rendered files
+		Root          string `json:"root"`          // Project root to resolve relative paths against
+		SourceDir     string `json:"sourceDir"`     // Directory to extract code blocks to in the external mode
+		Directive     string `json:"directive"`     // Keyword in the opening fence that marks a code block for rendering
+		InputFormat   string `json:"inputFormat"`   // Format of the input files: auto, markdown, asciidoc
+		AllowList     string `json:"allowList"`     // Renderer binaries that are allowed to run, comma separated
+		DenyList      string `json:"denyList"`      // Renderer binaries that are not allowed to run, comma separated
+		HashStyle     string `json:"hashStyle"`     // How to store the hash of custom filenames: comment, attribute
+		NewlineEOF    string `json:"newlineEOF"`    // How rewritten files end: preserve, ensure, strip
+		Encoding      string `json:"encoding"`      // Character encoding of the input files, e.g. utf-16le or latin1
+		HashLength    int    `json:"hashLength"`    // Length of the hashes in auto-generated filenames and hash comments: 8, 32
+
+		OutputStyle   string `json:"outputStyle"`   // Syntax of the line linking to a rendered image: markdown, hugo, docusaurus
+		ImageTemplate string `json:"imageTemplate"` // Template for the line linking to a rendered image
+		ImageRegexp   string `json:"imageRegexp"`   // Regexp to detect previously rendered images built from ImageTemplate
+		TemplateFile  string `json:"templateFile"`  // Path to a Go template building the lines that replace each code block
+
+		PartialWrites bool `json:"partialWrites"` // Write successfully rendered code blocks even if others fail
+		WarnOnError   bool `json:"warnOnError"`   // Print render failures as warnings and leave the code blocks unchanged, without failing
+		StableOrder   bool `json:"stableOrder"`   // Print the output of code blocks rendered concurrently in their order
+		IfMissing     bool `json:"ifMissing"`     // Render code blocks whose image file does not exist, even if the hash is unchanged
+		Shard         bool `json:"shard"`         // Place rendered files into subdirectories by hash prefix
+		NoRewrite     bool `json:"noRewrite"`     // Only write rendered images, without modifying the input files
+		VerifyOutputs bool `json:"verifyOutputs"` // Re-render code blocks whose image is incomplete or malformed, even if the hash is unchanged
+		VerifyLinks   bool `json:"verifyLinks"`   // Check that the links to rendered files resolve to existing files
+		ValidateSVG   bool `json:"validateSVG"`   // Fail code blocks whose renderer outputs an SVG that is not well-formed
+
+		TrimTrailingWhitespace bool `json:"trimTrailingWhitespace"` // Remove trailing whitespace from the lines of rendered code blocks
+		PortableSVG            bool `json:"portableSVG"`            // Rewrite absolute paths embedded in rendered SVGs
+		Deterministic          bool `json:"deterministic"`          // Strip the content of rendered SVGs that changes between renders of the same source
+		Provenance             bool `json:"provenance"`             // Record the file and line of the code block in rendered images
+		ImageDimensions        bool `json:"imageDimensions"`        // Include the width and height of rendered images in the image line
+		AltFromComment         bool `json:"altFromComment"`         // Use the leading comment of code blocks as the alt text of their image
+		ReplaceExistingImages  bool `json:"replaceExistingImages"`  // Replace hand-placed images following code blocks rendered for the first time
+
+		MaxWidth   int    `json:"maxWidth"`   // Maximum width of rendered images, in pixels
+		Background string `json:"background"` // Background color of rendered images, e.g. #1e1e1e
+		Locale     string `json:"locale"`     // Locale of the alt text to write for images
+		OutputExt  string `json:"outputExt"`  // Format to render every code block to, overriding the render options: svg, png
+		Trim       bool   `json:"trim"`       // Trim the whitespace around rendered images
+		Picture    bool   `json:"picture"`    // Also render SVG images as PNG, linked to as a fallback with a <picture> element
+
+		WrapLabels int `json:"wrapLabels"` // Wrap the labels of dot code blocks longer than this many characters
+
+		Incremental string `json:"incremental"` // Path to a state file recording the files rendered by previous runs, to skip unchanged files
+		Lockfile    string `json:"lockfile"`    // Path to a lockfile recording the hash and image of each code block
+		Frozen      bool   `json:"frozen"`      // Fail if a code block does not match the lockfile, instead of updating it
+
+		MaxFileSize   int64  `json:"maxFileSize"`   // Skip input files larger than this size, in bytes
+		MaxImageBytes int64  `json:"maxImageBytes"` // Fail if a rendered image is larger than this size, in bytes
+		DebugDir      string `json:"debugDir"`      // Directory to write renderer inputs and errors to, for debugging
+		Manifest      string `json:"manifest"`      // Path to write a JSON manifest of the rendered images to
+		LFSReport     bool   `json:"lfsReport"`     // Print which generated files are binary and which are text, with a suggested .gitattributes
+		Gitattributes string `json:"gitattributes"` // Path to write a suggested .gitattributes for the generated files to
+		Output        string `json:"output"`        // Path to write the rendered content of a remote input file to, instead of stdout
+		CacheDir      string `json:"cacheDir"`      // Directory to cache the output of renderers in, by their input
+		Quiet         bool   `json:"quiet"`         // Do not print progress or rendered files
+		Preview       bool   `json:"preview"`       // Open the images rendered from each file in the default viewer
+		Jobs          int    `json:"jobs"`          // Maximum number of files to process, and of renderer commands to run, concurrently
+		JobsPerFile   int    `json:"jobsPerFile"`   // Maximum number of code blocks to render concurrently within a file
+		BatchPlantUML bool   `json:"batchPlantUML"` // Render the plantuml code blocks of each file in a single plantuml process
+
+		RenderEnv []string `json:"renderEnv"` // Environment variables to set for renderer commands, in the form KEY=VALUE
+
+		Preludes     map[string]string `json:"preludes"`     // Content to inject at the start of code blocks before rendering, by language
+		Postludes    map[string]string `json:"postludes"`    // Content to inject at the end of code blocks before rendering, by language
+		HashPreludes bool              `json:"hashPreludes"` // Include the preludes and postludes when hashing code blocks
+
+		HashRendererVersion bool `json:"hashRendererVersion"` // Record the renderer version next to rendered images, re-rendering when it changes
+		Strict              bool `json:"strict"`              // Fail on unknown keys in render options
+
+		PostRenderHook string `json:"postRenderHook"` // Command to run on each rendered file, with its path as the last argument
+
+		DefaultModes      map[string]string `json:"defaultModes"`      // Mode of code blocks that do not specify one, by language
+		DefaultExtensions map[string]string `json:"defaultExtensions"` // Format of code blocks that do not specify one, by language
+		LanguageAliases   map[string]string `json:"languageAliases"`   // Alternate names of languages in code blocks, mapped to the language they are rendered as
+	} `json:"render"`
 }
 
 var config Config
@@ -26,13 +118,23 @@ func NewRootCmd() *cobra.Command {
 		Short: "A processor to render code blocks in Markdown files",
 		Long:  ``,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-			return nil
+			return loadConfigFile(cmd)
 		},
 		SilenceUsage: true,
 	}
+	cmd.PersistentFlags().StringVar(&config.ConfigFile, "config", "", fmt.Sprintf("Path to a JSON config file. Defaults to %s if it exists. Flags take precedence over the config file.", defaultConfigFile))
 
 	cmd.AddCommand(NewRenderCmd())
 	cmd.AddCommand(NewCleanCmd())
+	cmd.AddCommand(NewValidateCmd())
+	cmd.AddCommand(NewDiffCmd())
+	cmd.AddCommand(NewFlattenCmd())
+	cmd.AddCommand(NewListCmd())
+	cmd.AddCommand(NewStatsCmd())
+	cmd.AddCommand(NewGalleryCmd())
+	cmd.AddCommand(NewDoctorCmd())
+	cmd.AddCommand(NewWarmCacheCmd())
+	cmd.AddCommand(NewNormalizeHashesCmd())
 	return cmd
 }
 
@@ -53,3 +155,32 @@ func validateFileExists(filePath string) error {
 	}
 	return nil
 }
+
+// writeFileAtomic writes content to a temp file in the same directory as
+// filePath, then renames it into place. Readers never observe a partially
+// written file.
+func writeFileAtomic(filePath string, content []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpFilePath := f.Name()
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFilePath, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmpFilePath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpFilePath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestEntry describes a rendered image and the code block it was rendered
+// from.
+type ManifestEntry struct {
+	SourceFile string `json:"sourceFile"`
+	Line       int    `json:"line"`
+	Language   string `json:"language"`
+	Image      string `json:"image"`
+	Hash       string `json:"hash"`
+}
+
+// The manifest of the current batch render, if requested
+var manifest = []ManifestEntry{}
+
+// addManifestEntries adds an entry for each code block in the file whose image
+// is up to date, whether it was rendered in this run or before. Each view of
+// a structurizr workspace has an entry of its own.
+func addManifestEntries(filePath string, renderedFile *RenderedFile, outputDir string) {
+	isRendered := make(map[*Chunk]bool)
+	for _, chunk := range renderedFile.RenderedChunks {
+		isRendered[chunk] = true
+	}
+	for _, chunk := range renderedFile.Chunks {
+		if !chunk.IsRenderable || !isRendered[chunk] && chunk.ShouldRender(outputDir) {
+			continue
+		}
+		images := []string{chunk.OutputFilename()}
+		images = append(images, chunk.ViewFilenames()...)
+		for _, v := range images {
+			manifest = append(manifest, ManifestEntry{
+				SourceFile: filePath,
+				Line:       renderedFile.OutputCodeBlockIndexes[chunk] + 1,
+				Language:   chunk.Language,
+				Image:      path.Join(outputDir, chunk.OutputSubdir(), v),
+				Hash:       chunk.HashContent(),
+			})
+		}
+	}
+}
+
+// writeManifest writes the manifest as a JSON array.
+func writeManifest(filePath string) error {
+	b, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "marshal manifest")
+	}
+	err = writeFileAtomic(filePath, append(b, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "write manifest")
+	}
+	return nil
+}
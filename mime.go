@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"unicode/utf8"
+)
+
+// Media types of the output formats
+var outputMIMETypes = map[string]string{
+	"svg":  "image/svg+xml",
+	"png":  "image/png",
+	"json": "application/json",
+}
+
+// mimeTypeFromExt returns the media type of an output format. Unknown formats
+// are an error rather than a generic type, since browsers refuse to display
+// images with the wrong type.
+func mimeTypeFromExt(ext string) (string, error) {
+	mimeType, ok := outputMIMETypes[ext]
+	if !ok {
+		return "", fmt.Errorf("unknown media type for format %s", ext)
+	}
+	return mimeType, nil
+}
+
+// buildDataURI builds a data URI embedding the rendered content, with the
+// media type of its format. SVGs are percent-encoded as UTF-8, which is
+// smaller than base64 for text. Other formats, and SVGs that are not valid
+// UTF-8, are base64-encoded.
+func buildDataURI(content []byte, ext string) (string, error) {
+	mimeType, err := mimeTypeFromExt(ext)
+	if err != nil {
+		return "", err
+	}
+	if ext == "svg" && utf8.Valid(content) {
+		return "data:" + mimeType + ";charset=utf-8," + url.PathEscape(string(content)), nil
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
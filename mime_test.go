@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMIMETypeFromExt(t *testing.T) {
+	tests := []struct {
+		ext      string
+		expected string
+		wantErr  bool
+	}{
+		{ext: "svg", expected: "image/svg+xml"},
+		{ext: "png", expected: "image/png"},
+		{ext: "json", expected: "application/json"},
+		{ext: "gif", wantErr: true},
+		{ext: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ext, func(t *testing.T) {
+			actual, err := mimeTypeFromExt(tt.ext)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBuildDataURI(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		ext      string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "svg as utf-8",
+			content:  `<svg><text>é #1</text></svg>`,
+			ext:      "svg",
+			expected: "data:image/svg+xml;charset=utf-8,%3Csvg%3E%3Ctext%3E%C3%A9%20%231%3C%2Ftext%3E%3C%2Fsvg%3E",
+		},
+		{
+			name:     "svg that is not valid utf-8 as base64",
+			content:  "<svg>\xff</svg>",
+			ext:      "svg",
+			expected: "data:image/svg+xml;base64,PHN2Zz7/PC9zdmc+",
+		},
+		{
+			name:     "png as base64",
+			content:  "\x89PNG",
+			ext:      "png",
+			expected: "data:image/png;base64,iVBORw==",
+		},
+		{
+			name:    "unknown format",
+			content: "GIF89a",
+			ext:     "gif",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := buildDataURI([]byte(tt.content), tt.ext)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewNormalizeHashesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "normalize-hashes",
+		Short: "Rewrite the hashes of rendered images in markdown files to the length set by --hash-length, renaming the images accordingly",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && config.FilesFrom == "" {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: normalizeHashesCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func normalizeHashesCmd(cmd *cobra.Command, args []string) error {
+	err := prepareRender()
+	if err != nil {
+		return err
+	}
+	if config.Render.HashLength == 0 {
+		return errors.New("no hash length specified, set --hash-length or render.hashLength in the config file")
+	}
+	args, err = inputFiles(args)
+	if err != nil {
+		return err
+	}
+	languages := strings.Split(config.Render.Languages, ",")
+	for _, v := range args {
+		filePath := resolveRootPath(v)
+		err := normalizeHashesFile(filePath, languages, config.Render.OutputDir)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("normalize hashes for file %s", v))
+		}
+	}
+	return nil
+}
+
+// fileRename is a file renamed while normalizing hashes, kept so that the
+// renames can be undone if the file fails to be normalized.
+type fileRename struct {
+	oldPath string
+	newPath string
+}
+
+// normalizeHashesFile rewrites the hashes of the file's rendered images to the
+// configured length. Auto-generated filenames are renamed, along with their
+// PNG fallbacks and external source files, and custom filenames have the hash
+// stored next to them rewritten. Images that are out of date are left as they
+// are, since their hash no longer identifies their content.
+func normalizeHashesFile(filePath string, types []string, outputDir string) (err error) {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(content, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return err
+	}
+
+	// Undo the renames if the file is not rewritten, so that the images
+	// stay linked to
+	var renames []fileRename
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(renames) - 1; i >= 0; i-- {
+			if renameErr := os.Rename(renames[i].newPath, renames[i].oldPath); renameErr != nil {
+				warnf("Failed to restore %s: %s\n", renames[i].oldPath, renameErr)
+			}
+		}
+	}()
+	renameFile := func(oldPath, newPath string) error {
+		if _, err := os.Stat(oldPath); os.IsNotExist(err) {
+			// Already renamed for an identical code block
+			return nil
+		}
+		if err := os.Rename(oldPath, newPath); err != nil {
+			return errors.Wrap(err, "rename file")
+		}
+		renames = append(renames, fileRename{oldPath, newPath})
+		return nil
+	}
+
+	var outputLines []string
+	for _, chunk := range chunks {
+		if chunk.IsRenderable && chunk.RenderedHash != "" {
+			if config.Render.TrimTrailingWhitespace {
+				chunk.TrimTrailingWhitespace()
+			}
+			if chunk.ShouldRender(outputDir) {
+				warnf("[%s:%d] Skipped, the image is out of date and must be rendered first\n", filePath, chunk.CodeBlockIndex+1)
+			} else if err := normalizeChunkHash(chunk, outputDir, renameFile); err != nil {
+				return errors.Wrap(err, fmt.Sprintf("line %d: normalize hash", chunk.CodeBlockIndex+1))
+			}
+		}
+		outputLines = append(outputLines, chunk.Lines...)
+	}
+
+	outputContent := strings.Join(outputLines, "\n")
+	if outputContent == content {
+		return nil
+	}
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return errors.Wrap(err, "stat file")
+	}
+	b, err := encodeOutput(outputContent)
+	if err != nil {
+		return err
+	}
+	err = writeFileAtomic(filePath, b, fileInfo.Mode().Perm())
+	if err != nil {
+		return errors.Wrap(err, "write file")
+	}
+	logf("[%s] Normalized hashes\n", filePath)
+	return nil
+}
+
+// normalizeChunkHash rewrites the hash of the chunk's rendered image to the
+// configured length, renaming its files with renameFile.
+func normalizeChunkHash(chunk *Chunk, outputDir string, renameFile func(oldPath, newPath string) error) error {
+	oldHash := chunk.RenderedHash
+	newHash := chunk.HashContent()[:config.Render.HashLength]
+	if oldHash == newHash {
+		return nil
+	}
+	imageLine := chunk.Lines[chunk.ImageRelativeLineIndex]
+
+	// Custom filenames keep their name, only the hash next to them changes
+	if chunk.RenderOptions.Filename != "" {
+		chunk.Lines[chunk.ImageRelativeLineIndex] = strings.ReplaceAll(imageLine, oldHash, newHash)
+		return nil
+	}
+
+	oldName := "render-" + oldHash
+	newName := "render-" + newHash
+	dir := path.Join(outputDir, chunk.OutputSubdir())
+	for _, ext := range []string{"svg", "png", "json", "html"} {
+		err := renameFile(path.Join(dir, oldName+"."+ext), path.Join(dir, newName+"."+ext))
+		if err != nil {
+			return err
+		}
+	}
+	// The views of structurizr workspaces after the first, named
+	// render-<hash>.<key>.<ext>
+	if chunk.Language == "structurizr" {
+		viewPaths, err := filepath.Glob(filepath.Join(filepath.FromSlash(dir), oldName+".*.*"))
+		if err != nil {
+			return errors.Wrap(err, "list views")
+		}
+		for _, v := range viewPaths {
+			err := renameFile(v, filepath.Join(filepath.Dir(v), newName+strings.TrimPrefix(filepath.Base(v), oldName)))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	chunk.Lines[chunk.ImageRelativeLineIndex] = strings.ReplaceAll(imageLine, oldName, newName)
+
+	// Rename the external source file extracted from the code block
+	if chunk.SourceLink != "" && strings.HasPrefix(path.Base(chunk.SourceLink), oldName+".") {
+		newSourceLink := path.Join(path.Dir(chunk.SourceLink), newName+strings.TrimPrefix(path.Base(chunk.SourceLink), oldName))
+		newSourceFilePath := filepath.Join(chunk.FileDir, filepath.FromSlash(newSourceLink))
+		err := renameFile(chunk.SourceFilePath, newSourceFilePath)
+		if err != nil {
+			return err
+		}
+		for i, line := range chunk.Lines {
+			if line == buildSourceLink(chunk.SourceLink) {
+				chunk.Lines[i] = buildSourceLink(newSourceLink)
+			}
+		}
+		chunk.SourceLink = newSourceLink
+		chunk.SourceFilePath = newSourceFilePath
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Languages whose renderers can output PNG, in addition to SVG
+var pngLanguages = []string{"dot", "plantuml", "mscgen", "excalidraw", "erd", "python-plot"}
+
+// HasFallback checks if the chunk's SVG image has a PNG fallback, rendered
+// with --picture for environments that do not support SVG. Only Markdown
+// images without a custom template can link to the fallback. With
+// --output-ext, every image has a single format, so there is no fallback.
+func (r *Chunk) HasFallback() bool {
+	if !config.Render.Picture || config.Render.OutputExt != "" || r.IsDataOutput() || r.OutputFormat() != "svg" {
+		return false
+	}
+	if _, ok := r.Format.(MarkdownFormat); !ok || imageTemplate != nil {
+		return false
+	}
+	for _, v := range pngLanguages {
+		if v == r.Language {
+			return true
+		}
+	}
+	return false
+}
+
+// FallbackFilename returns the filename the chunk's PNG fallback is rendered
+// to, which is the filename of the SVG with the extension replaced.
+func (r *Chunk) FallbackFilename() string {
+	fileName := r.OutputFilename()
+	return strings.TrimSuffix(fileName, ".svg") + ".png"
+}
+
+// fallbackChunk returns a copy of the chunk rendering its PNG fallback.
+func (r *Chunk) fallbackChunk() *Chunk {
+	fallback := *r
+	fallback.RenderOptions.Format = "png"
+	return &fallback
+}
+
+// renderFallback renders the chunk's PNG fallback.
+func (r *Chunk) renderFallback() ([]byte, error) {
+	content, err := r.fallbackChunk().renderContent()
+	if err != nil {
+		return nil, err
+	}
+	if config.Render.Trim {
+		content, err = trimPNG(r.stderrOutput(), content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Render.Provenance {
+		content = r.addProvenance(content, "png")
+	}
+	return content, nil
+}
+
+// buildPictureImage builds a <picture> element that displays the SVG, falling
+// back to the PNG. If hash is not empty, it is included as a data-hash
+// attribute of the <img> tag.
+func buildPictureImage(outputFilename, fallbackFilename, linkPrefix, alt, hash string, dimensions *ImageDimensions) string {
+	image := buildHTMLImage(fallbackFilename, linkPrefix, alt, hash, dimensions)
+	mimeType, _ := mimeTypeFromExt("svg")
+	return fmt.Sprintf(`<picture><source srcset="%s" type="%s">%s</picture>`, linkPrefix+outputFilename, mimeType, image)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Match the header of a graphviz graph, up to and including the opening
+// brace. Leading comments, and quoted graph IDs which may contain braces, are
+// accounted for.
+var dotGraphHeaderRegexp = regexp.MustCompile(`^(?s)(?:\s+|//[^\n]*|#[^\n]*|/\*.*?\*/)*(?:strict\s+)?(?:di)?graph\b\s*(?:"(?:[^"\\]|\\.)*"|[^{"]*)\s*\{`)
+
+// applyPreludes injects the configured prelude and postlude for the language
+// into the code block content. For graphviz, they are injected into the graph
+// body, right after the opening brace and right before the closing brace,
+// since attribute statements are not valid outside of it. For other
+// languages, they are prepended and appended.
+func applyPreludes(language string, content string) string {
+	return injectContent(language, content, config.Render.Preludes[language], config.Render.Postludes[language])
+}
+
+// injectPlantUMLScale limits the width of a PlantUML diagram with the scale
+// directive, which must be placed within the @startuml block if there is one.
+func injectPlantUMLScale(content string, maxWidth int) string {
+	return injectPlantUMLDirective(content, fmt.Sprintf("scale max %d width", maxWidth))
+}
+
+// injectPlantUMLDirective injects a directive at the start of a PlantUML
+// diagram, within the @startuml block if there is one.
+func injectPlantUMLDirective(content string, directive string) string {
+	lines := strings.Split(content, "\n")
+	for i, v := range lines {
+		if strings.HasPrefix(strings.TrimSpace(v), "@start") {
+			lines = append(lines[:i+1], append([]string{directive}, lines[i+1:]...)...)
+			return strings.Join(lines, "\n")
+		}
+	}
+	return directive + "\n" + content
+}
+
+// injectContent injects the prelude and postlude into the code block content,
+// as described in applyPreludes.
+func injectContent(language string, content string, prelude string, postlude string) string {
+	switch language {
+	case "dot":
+		if prelude != "" {
+			loc := dotGraphHeaderRegexp.FindStringIndex(content)
+			if loc != nil {
+				content = content[:loc[1]] + "\n" + prelude + "\n" + content[loc[1]:]
+			} else {
+				content = prelude + "\n" + content
+			}
+		}
+		if postlude != "" {
+			idx := strings.LastIndex(content, "}")
+			if idx >= 0 {
+				content = content[:idx] + postlude + "\n" + content[idx:]
+			} else {
+				content = content + "\n" + postlude
+			}
+		}
+	default:
+		if prelude != "" {
+			content = prelude + "\n" + content
+		}
+		if postlude != "" {
+			content = content + "\n" + postlude
+		}
+	}
+	return content
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// previewRenderedFile opens the images rendered from the file with --preview,
+// in an HTML page embedding them. It is a no-op when not run interactively,
+// and failures only warn, since the images have been written regardless.
+func previewRenderedFile(filePath string, renderedFile *RenderedFile) {
+	if !isTerminal(os.Stdout) || !isTerminal(os.Stderr) {
+		return
+	}
+	var images []string
+	for _, chunk := range renderedFile.RenderedChunks {
+		if chunk.IsDataOutput() {
+			continue
+		}
+		images = append(images, chunk.OutputFilePath)
+	}
+	if len(images) == 0 {
+		return
+	}
+	pagePath, err := writePreviewPage(filePath, images)
+	if err == nil {
+		err = openInViewer(pagePath)
+	}
+	if err != nil {
+		warnf("[%s] Failed to preview rendered images: %s\n", filePath, err)
+	}
+}
+
+// writePreviewPage writes an HTML page embedding the images to a temporary
+// file, which is left in place for the viewer to read.
+func writePreviewPage(filePath string, images []string) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n", html.EscapeString(filePath))
+	for _, v := range images {
+		abs, err := filepath.Abs(v)
+		if err != nil {
+			return "", errors.Wrap(err, "get image path")
+		}
+		src := (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String()
+		fmt.Fprintf(&b, "<figure><img src=\"%s\"><figcaption>%s</figcaption></figure>\n", html.EscapeString(src), html.EscapeString(path.Base(filepath.ToSlash(v))))
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	f, err := os.CreateTemp("", "md-code-renderer-preview-*.html")
+	if err != nil {
+		return "", errors.Wrap(err, "create preview page")
+	}
+	defer f.Close()
+	_, err = f.WriteString(b.String())
+	if err != nil {
+		return "", errors.Wrap(err, "write preview page")
+	}
+	return f.Name(), nil
+}
+
+// openInViewer opens the file in the default viewer of the platform, without
+// waiting for the viewer to exit.
+func openInViewer(filePath string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", filePath)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", filePath)
+	default:
+		cmd = exec.Command("xdg-open", filePath)
+	}
+	err := cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "open viewer")
+	}
+	return cmd.Process.Release()
+}
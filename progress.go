@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Progress displays the progress of a batch render on stderr. It is only
+// displayed when stderr is a terminal, and can be suppressed with --quiet.
+type Progress struct {
+	enabled bool
+	total   int
+	line    string
+}
+
+// The progress of the current batch render, if any
+var progress = &Progress{}
+
+// Held while printing, so that the messages of files processed concurrently
+// and the progress line do not interleave
+var outputMutex sync.Mutex
+
+func NewProgress(total int) *Progress {
+	return &Progress{
+		enabled: !config.Render.Quiet && isTerminal(os.Stderr),
+		total:   total,
+	}
+}
+
+// Update displays the file currently being processed.
+func (p *Progress) Update(current int, filePath string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	p.line = fmt.Sprintf("[%d/%d] %s", current, p.total, filePath)
+	p.draw()
+}
+
+// Clear removes the progress line from the terminal.
+func (p *Progress) Clear() {
+	if p.enabled && p.line != "" {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// Done clears the progress line for good.
+func (p *Progress) Done() {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	p.Clear()
+	p.line = ""
+}
+
+func (p *Progress) draw() {
+	if p.enabled && p.line != "" {
+		fmt.Fprint(os.Stderr, "\r\033[K"+p.line)
+	}
+}
+
+// Where informational messages are printed to. Stdout, unless it carries the
+// rendered content of remote input files.
+var logOutput io.Writer = os.Stdout
+
+// logf prints an informational message to stdout, unless --quiet is set. The
+// progress line is redrawn after the message.
+func logf(format string, args ...interface{}) {
+	if config.Render.Quiet {
+		return
+	}
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	progress.Clear()
+	fmt.Fprintf(logOutput, format, args...)
+	progress.draw()
+}
+
+// warnf prints a warning to stderr. The progress line is redrawn after the
+// warning.
+func warnf(format string, args ...interface{}) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	progress.Clear()
+	fmt.Fprintf(os.Stderr, format, args...)
+	progress.draw()
+}
+
+// LogBuffer holds output for stderr to print later, e.g. the stderr of the
+// renderer of a code block rendered concurrently with --stable-order, so that
+// it is printed in the order of the code blocks rather than as it happens.
+type LogBuffer struct {
+	mutex  sync.Mutex
+	output []byte
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.output = append(b.output, p...)
+	return len(p), nil
+}
+
+// Flush prints the buffered output.
+func (b *LogBuffer) Flush() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.output) > 0 {
+		warnf("%s", b.output)
+	}
+	b.output = nil
+}
+
+func isTerminal(f *os.File) bool {
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fileInfo.Mode()&os.ModeCharDevice != 0
+}
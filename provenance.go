@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+)
+
+// The signature PNG files start with
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Location returns the file and line of the chunk's code block, e.g.
+// docs/arch.md:42, or an empty string if the file is not known.
+func (r *Chunk) Location() string {
+	if r.FilePath == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", filepath.ToSlash(r.FilePath), r.CodeBlockIndex+1)
+}
+
+// addProvenance records the location of the code block an image was rendered
+// from in the image, with --provenance, so that images collected into a shared
+// directory can be traced back to their source. SVG images get a comment
+// before the root element, e.g. <!-- source: docs/arch.md:42 -->, and PNG
+// images a tEXt chunk with the Source keyword.
+func (r *Chunk) addProvenance(content []byte, ext string) []byte {
+	location := r.Location()
+	if location == "" {
+		return content
+	}
+	switch ext {
+	case "svg":
+		loc := svgRootTagRegexp.FindIndex(content)
+		if loc == nil {
+			return content
+		}
+		// Comments must not contain --
+		comment := "<!-- source: " + strings.ReplaceAll(location, "--", "- -") + " -->\n"
+		return append(append(append([]byte{}, content[:loc[0]]...), comment...), content[loc[0]:]...)
+	case "png":
+		return addPNGText(content, "Source", location)
+	default:
+		return content
+	}
+}
+
+// addPNGText adds a tEXt chunk to a PNG image, after the IHDR chunk that
+// must come first. Content that is not a PNG image is returned as is.
+func addPNGText(content []byte, keyword string, text string) []byte {
+	// The signature, followed by the length, type, data, and CRC of IHDR
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if len(content) < ihdrEnd || !bytes.HasPrefix(content, pngSignature) || string(content[12:16]) != "IHDR" {
+		return content
+	}
+	data := append([]byte(keyword+"\x00"), text...)
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	result := append([]byte{}, content[:ihdrEnd]...)
+	result = append(result, chunk...)
+	return append(result, content[ihdrEnd:]...)
+}
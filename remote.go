@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// How long to wait for a remote input file to be fetched
+const remoteInputTimeout = 30 * time.Second
+
+// isRemoteInput checks if the input file is an http(s) URL. Remote input files
+// are fetched instead of read, and since they cannot be edited in place, the
+// rendered content is written to --output or stdout.
+func isRemoteInput(filePath string) bool {
+	return strings.HasPrefix(filePath, "http://") || strings.HasPrefix(filePath, "https://")
+}
+
+// fetchRemoteInput fetches the content of a remote input file. Like local input
+// files, it may not be larger than --max-file-size.
+func fetchRemoteInput(rawURL string) (string, error) {
+	client := &http.Client{Timeout: remoteInputTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("fetch %s", rawURL))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetch %s: unexpected status %s", rawURL, resp.Status)
+	}
+	var body io.Reader = resp.Body
+	if config.Render.MaxFileSize > 0 {
+		// Read one byte more than allowed, to tell if the limit is exceeded
+		body = io.LimitReader(resp.Body, config.Render.MaxFileSize+1)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("read %s", rawURL))
+	}
+	if config.Render.MaxFileSize > 0 && int64(len(b)) > config.Render.MaxFileSize {
+		return "", fmt.Errorf("fetch %s: file size exceeds the maximum of %d bytes", rawURL, config.Render.MaxFileSize)
+	}
+	return decodeInput(b)
+}
+
+// inputFileDir returns the directory relative paths in the input file are
+// resolved against. Remote input files have none, so it is empty, and their
+// code blocks cannot read local files.
+func inputFileDir(filePath string) string {
+	if isRemoteInput(filePath) {
+		return ""
+	}
+	return filepath.Dir(filePath)
+}
+
+// inputFilePath returns the path of the input file used to infer its format.
+// For remote input files, it is the path of the URL, without the query.
+func inputFilePath(filePath string) string {
+	if !isRemoteInput(filePath) {
+		return filePath
+	}
+	u, err := url.Parse(filePath)
+	if err != nil {
+		return filePath
+	}
+	return path.Base(u.Path)
+}
+
+// writeRemoteOutput writes the rendered content of a remote input file to
+// --output, or to stdout if not set.
+func writeRemoteOutput(content string) error {
+	b, err := encodeOutput(content)
+	if err != nil {
+		return err
+	}
+	if config.Render.Output == "" {
+		_, err := os.Stdout.Write(b)
+		return errors.Wrap(err, "write to stdout")
+	}
+	err = writeFileAtomic(config.Render.Output, b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "write output file")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveRemoteInput serves content as a remote input file, returning its URL.
+func serveRemoteInput(t *testing.T, content string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/doc.md"
+}
+
+func TestRemoteInputCannotReadLocalFiles(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "secret.dot", "digraph { secret }\n")
+	writeTestFile(t, dir, "secret.csv", "from,to\na,b\n")
+	tests := []struct {
+		name    string
+		options string
+	}{
+		{"include", `{"include":"secret.dot"}`},
+		{"include_before", `{"include_before":["secret.dot"]}`},
+		{"data", `{"data":"secret.csv","template":"{{quote .from}} -> {{quote .to}}"}`},
+		{"external", `{"mode":"external"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := serveRemoteInput(t, "```dot render"+tt.options+"\ndigraph { a }\n```\n")
+			err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--output", filepath.Join(dir, "out.md"), url)
+			if err == nil || !strings.Contains(err.Error(), "not supported in remote input files") {
+				t.Errorf("expected the option to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoteInputMaxFileSize(t *testing.T) {
+	dir := t.TempDir()
+	url := serveRemoteInput(t, strings.Repeat("a", 101))
+	err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--output", filepath.Join(dir, "out.md"), "--max-file-size", "100", url)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum of 100 bytes") {
+		t.Errorf("expected the input file to be rejected, got %v", err)
+	}
+
+	url = serveRemoteInput(t, strings.Repeat("a", 100))
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--output", filepath.Join(dir, "out.md"), "--max-file-size", "100", url)
+	if err != nil {
+		t.Errorf("expected an input file of the maximum size to be rendered, got %v", err)
+	}
+}
@@ -11,41 +11,183 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 )
 
+// Match a full hash (32 characters) or a short hash (8 characters). Filenames
+// use full hashes and hash comments use short hashes, unless --hash-length is
+// set.
+const hashPattern = `[0-9a-f]{32}|[0-9a-f]{8}`
+
 // Match: ![render-db6d08bb022ed12c2cc74d86d7a4707d.svg](/optional/path/to/render-db6d08bb022ed12c2cc74d86d7a4707d.svg)
 // Capture group on the hash.
-var renderedImageRegexp = regexp.MustCompile(`!\[render-.{32}\..+\]\(.*render-(.{32})\..+\)`)
+var renderedImageRegexp = regexp.MustCompile(`!\[.*\]\(.*render-(` + hashPattern + `)\..+\)`)
+
+// Match: <!-- hash:db6d08bb --> or <!-- hash:db6d08bb renderer:dot@2.43.0 -->
+// Capture group on the hash.
+var renderedHashRegexp = regexp.MustCompile(`<!-- hash:(` + hashPattern + `)(?: renderer:\S+)? -->`)
+
+// Match: <!-- renderer:dot@2.43.0 --> or <!-- hash:db6d08bb renderer:dot@2.43.0 -->
+// Capture group on the renderer marker.
+var rendererMarkerRegexp = regexp.MustCompile(`<!-- (?:hash:(?:` + hashPattern + `) )?renderer:(\S+) -->`)
+
+// Match: <img src="/optional/path/to/render-db6d08bb022ed12c2cc74d86d7a4707d.svg" alt="...">
+// Capture group on the hash.
+var renderedHTMLImageRegexp = regexp.MustCompile(`<img src="[^"]*render-(` + hashPattern + `)\.[^"]+"[^>]*>`)
+
+// Match: <img src="filename.ext" alt="filename.ext" data-hash="db6d08bb">
+// Capture group on the hash.
+var renderedHashAttributeRegexp = regexp.MustCompile(`<img [^>]*data-hash="(` + hashPattern + `)"[^>]*>`)
 
-var renderedHashRegexp = regexp.MustCompile(`<!-- hash:(.{8}) -->`)
+// Match: [graph data](/optional/path/to/filename.ext)
+// Capture group on the link.
+var dataLinkRegexp = regexp.MustCompile(`\[graph data\]\((.+?)\)`)
 
 // Match: ![alt text](filename.ext)
 // Capture group on the filename.
 var markdownImageRegexp = regexp.MustCompile(`!\[.*\]\((.+)\)`)
 
-var (
-	defaultRenderMode    = "normal"
-	defaultRenderOptions = RenderOptions{Mode: defaultRenderMode}
-)
+// Match: <img src="filename.ext" alt="alt text">
+// Capture group on the filename.
+var htmlImageRegexp = regexp.MustCompile(`<img src="([^"]+)"[^>]*>`)
+
+// Match: [source](diagrams/render-db6d08bb022ed12c2cc74d86d7a4707d.dot)
+// Capture group on the source file path.
+var sourceLinkRegexp = regexp.MustCompile(`^\[source\]\((.+)\)$`)
+
+// Match: render-db6d08bb022ed12c2cc74d86d7a4707d.svg
+// Capture group on the hash.
+var renderedFilenameRegexp = regexp.MustCompile(`^render-(` + hashPattern + `)\.`)
+
+// Bounds the number of renderer commands running concurrently across all
+// files, if set
+var commandSemaphore chan struct{}
+
+// Template for the rendered image line, if a custom one is configured
+var imageTemplate *ImageTemplate
+
+// Languages that can be rendered
+var supportedLanguages = []string{"dot", "plantuml", "pikchr", "mscgen", "math", "excalidraw", "railroad", "erd", "python-plot", "chemfig", "structurizr"}
+
+var defaultRenderMode = "normal"
+
+// Format of the code blocks of each language that do not specify one, if not
+// svg. Can be overridden with render.defaultExtensions in the config file.
+var defaultExtensions = map[string]string{}
 
 type RenderOptions struct {
-	Mode     string `json:"mode"` // Modes: normal, code-collapsed, image-collapsed, code-hidden
+	Mode     string `json:"mode"` // Modes: normal, code-collapsed, code-collapsed-copy, image-collapsed, code-hidden, external
 	Filename string `json:"filename"`
+	Name     string `json:"name"`    // Name the filename is derived from as a slug, e.g. system-architecture.svg for System Architecture
+	Include  string `json:"include"` // Path to a file to render instead of the code block content, relative to the input file
+	Format   string `json:"format"`  // Formats: svg, png, json (dot only). Overrides the format inferred from the filename.
+
+	IncludeBefore []string `json:"include_before"` // Paths to snippet files to inject before the code block content, relative to the input file
+	MaxWidth      int      `json:"max_width"`      // Maximum width of the rendered image, in pixels. Overrides --max-width.
+
+	Alt        string            `json:"alt"`         // Alt text of the image. Defaults to the filename.
+	AltLocales map[string]string `json:"alt_locales"` // Alt text of the image by locale, selected with --locale
+	Caption    string            `json:"caption"`     // Caption written in italics in a paragraph below the image
+
+	Viewer     bool   `json:"viewer"`     // Link the image to a standalone HTML page with pan and zoom (svg only)
+	Background string `json:"background"` // Background color of the image, e.g. #1e1e1e. Overrides --background.
+
+	Rankdir string `json:"rankdir"` // Direction of the graph layout (dot only): TB, LR, BT, RL
+	Size    string `json:"size"`    // Maximum size of the drawing in inches (dot only), e.g. "8,5"
+
+	Tags []string `json:"tags"` // Tags selecting the code block for rendering with --tags, e.g. ["public"]
+
+	Data     string `json:"data"`     // Path to a CSV file expanded into dot statements, relative to the input file (dot only)
+	Template string `json:"template"` // Template executed for each row of the data file, e.g. {{quote .from}} -> {{quote .to}}
 }
 
+// Match: 8,5 or 7.5 or 8,5!
+var graphvizSizeRegexp = regexp.MustCompile(`^\d+(?:\.\d+)?(?:,\d+(?:\.\d+)?)?!?$`)
+
 func (o *RenderOptions) Validate() error {
 	if o.Mode == "" {
 		o.Mode = defaultRenderMode
 	}
 	switch o.Mode {
-	case "normal", "code-collapsed", "image-collapsed", "code-hidden":
+	case "normal", "code-collapsed", "code-collapsed-copy", "image-collapsed", "code-hidden", "external":
 	default:
 		return errors.New("unsupported mode")
 	}
+	if o.Filename != "" {
+		err := validateFilename(o.Filename)
+		if err != nil {
+			return errors.Wrap(err, "invalid filename")
+		}
+	}
+	if o.Name != "" {
+		if o.Filename != "" {
+			return errors.New("name and filename cannot both be set")
+		}
+		if slugify(o.Name) == "" {
+			return errors.New("name must contain letters or digits")
+		}
+	}
+	switch o.Format {
+	case "", "svg", "png", "json":
+	default:
+		return errors.New("unsupported format")
+	}
+	if o.Format != "" && o.Filename != "" {
+		ext := strings.TrimPrefix(filepath.Ext(o.Filename), ".")
+		if ext != "" && ext != o.Format {
+			return errors.New("format does not match the filename extension")
+		}
+	}
+	if o.MaxWidth < 0 {
+		return errors.New("max width must not be negative")
+	}
+	if err := validateBackground(o.Background); err != nil {
+		return err
+	}
+	// These are passed to dot as arguments, so only known values are allowed
+	switch o.Rankdir {
+	case "", "TB", "LR", "BT", "RL":
+	default:
+		return errors.New("unsupported rankdir, expected TB, LR, BT, or RL")
+	}
+	if o.Size != "" && !graphvizSizeRegexp.MatchString(o.Size) {
+		return errors.New("invalid size, expected the width and height in inches, e.g. 8,5")
+	}
+	if o.Caption != "" && o.Mode == "image-collapsed" {
+		return errors.New("caption is not supported in the image-collapsed mode")
+	}
+	if o.Template != "" && o.Data == "" {
+		return errors.New("template requires a data file")
+	}
+	if o.Include != "" && o.Mode == "external" {
+		return errors.New("include is not supported in the external mode")
+	}
+	for _, v := range o.Tags {
+		if strings.TrimSpace(v) == "" || strings.Contains(v, ",") {
+			return fmt.Errorf("invalid tag %q, tags must not be empty or contain commas", v)
+		}
+	}
+	return nil
+}
+
+// validateFilename ensures that a user-supplied filename cannot escape the
+// output directory, or be mistaken for a command-line flag.
+func validateFilename(filename string) error {
+	if strings.ContainsAny(filename, `/\`) {
+		return errors.New("must not contain path separators")
+	}
+	if filename == "." || filename == ".." {
+		return errors.New("must not be a relative directory")
+	}
+	if strings.HasPrefix(filename, "-") {
+		return errors.New("must not start with -")
+	}
 	return nil
 }
 
@@ -58,272 +200,1896 @@ type Chunk struct {
 
 	IsRenderable           bool
 	Language               string
+	WrittenLanguage        string // The language as written in the code block, before resolving any alias
 	ImageRelativeLineIndex int    // Where the image is located in the chunk. Index is relative to the chunk's lines.
 	RenderedHash           string // If image has been rendered before, contains the hash of the code block previously used to render the image
 	HasHashComment         bool
+	HasStaleHashComments   bool     // Whether the image line has accumulated more than one hash comment
+	RenderedRenderer       string   // If image has been rendered before with --hash-renderer-version, the renderer and version used
 	CodeBlockContent       []string // The contents of the code block
+	CodeBlockContentIndex  int      // Where the contents of the code block start. Index is relative to the input file.
 	RenderOptions          RenderOptions
+	IncludedBefore         string // Content of the include_before snippets, injected before the code block content
+	IncludedData           string // Statements expanded from the data file, injected at the end of the graph body
+
+	Format               InputFormat // Format of the input file
+	FileDir              string      // Directory of the input file, used to resolve relative paths
+	FilePath             string      // Path of the input file, if the chunk is rendered from one
+	SourceLink           string      // For the "external" mode, link to the external source file
+	SourceFilePath       string      // For the "external" mode, path to the external source file
+	HasPendingSourceFile bool        // For the "external" mode, whether the source file has yet to be written
+
+	RenderedContent []byte // The rendered image, held in memory until the chunk is committed
+	OutputFilePath  string // Where the rendered image will be written to when the chunk is committed
+
+	FallbackContent  []byte // With --picture, the rendered PNG fallback, held in memory until the chunk is committed
+	FallbackFilePath string // With --picture, where the PNG fallback will be written to when the chunk is committed
+
+	ViewerContent  []byte // With the viewer option, the viewer page, held in memory until the chunk is committed
+	ViewerFilePath string // With the viewer option, where the viewer page will be written to when the chunk is committed
+
+	Views []StructurizrView // For structurizr, the views after the first, held in memory until the chunk is committed
+
+	Log *LogBuffer // With --stable-order, the messages printed while rendering, held until all code blocks of the file are rendered
 }
 
-func (r *Chunk) ShouldRender() bool {
+func (r *Chunk) ShouldRender(outputDir string) bool {
 	if !r.IsRenderable {
 		return false
 	}
 
+	// Without rewriting, there is no hash to compare against, so only the
+	// existence of the image is checked.
+	if config.Render.IfMissing || config.Render.NoRewrite {
+		_, err := os.Stat(path.Join(outputDir, r.OutputSubdir(), r.OutputFilename()))
+		if os.IsNotExist(err) {
+			return true
+		}
+		if r.HasFallback() {
+			_, err := os.Stat(path.Join(outputDir, r.OutputSubdir(), r.FallbackFilename()))
+			if os.IsNotExist(err) {
+				return true
+			}
+		}
+		if r.HasViewer() {
+			_, err := os.Stat(path.Join(outputDir, r.OutputSubdir(), r.ViewerFilename()))
+			if os.IsNotExist(err) {
+				return true
+			}
+		}
+		for _, v := range r.ViewFilenames() {
+			_, err := os.Stat(path.Join(outputDir, r.OutputSubdir(), v))
+			if os.IsNotExist(err) {
+				return true
+			}
+		}
+		if config.Render.NoRewrite {
+			return false
+		}
+	}
+
+	// Re-render when the viewer option is toggled, to update the link
+	if r.RenderedHash != "" && r.HasViewer() != r.hasViewerLink() {
+		return true
+	}
+
+	// Re-render when the alt text changes, to update the image line
+	if r.HasStaleAlt() {
+		return true
+	}
+
+	// Re-render images previously rendered to another format
+	if config.Render.OutputExt != "" && r.RenderedHash != "" && !strings.Contains(r.Lines[r.ImageRelativeLineIndex], r.OutputFilename()) {
+		return true
+	}
+
+	// Re-render images rendered by a different version of the renderer
+	if config.Render.HashRendererVersion && r.RenderedHash != "" && r.RenderedRenderer != rendererMarker(r.Language) {
+		return true
+	}
+
 	// Support both a full hash (32 characters) and a short hash (8 characters)
 	hash := r.HashContent()
 	shortHash := hash[:8]
 	if r.HashContent() != r.RenderedHash && shortHash != r.RenderedHash {
 		return true
 	}
+
+	// Re-render images left incomplete by a previous render
+	if config.Render.VerifyOutputs && !r.HasValidOutputs(outputDir) {
+		return true
+	}
 	return false
 }
 
-func (r *Chunk) HashContent() string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(r.CodeBlockContent, "\n"))))
-}
+// Match the start of links in image lines: Markdown links, HTML attributes,
+// and AsciiDoc image macros
+const imageLinkStartPattern = `(\]\(|src="|srcset="|href="|image::)`
 
-func (r *Chunk) Render(outputDir string, linkPrefix string) (fileName string, err error) {
-	var content []byte
-	if r.RenderOptions.Filename != "" {
-		fileName = r.RenderOptions.Filename
-	} else {
-		fileName = "render-" + r.HashContent() + ".svg"
+var imageLinkStartRegexp = regexp.MustCompile(imageLinkStartPattern)
+
+// The characters that end a link in an image line
+const imageLinkEndChars = "\t\n\f\r ()<>[]\"'"
+
+// relinkImageLine updates the links of the chunk's previously rendered image
+// line to the link prefix, e.g. after --link-prefix is changed, for chunks
+// whose image is otherwise up to date. The links to the image and to the files
+// rendered next to it, e.g. its fallback, viewer page, and views, are updated
+// in place.
+func (r *Chunk) relinkImageLine(linkPrefix string) {
+	if r.RenderedHash == "" || config.Render.NoRewrite {
+		return
 	}
+	if subdir := r.OutputSubdir(); subdir != "" {
+		linkPrefix = linkPrefix + subdir + "/"
+	}
+	fileName := r.OutputFilename()
+	stem := strings.TrimSuffix(fileName, path.Ext(fileName))
 
-	codeBlockContent := strings.Join(r.CodeBlockContent, "\n")
-	switch r.Language {
-	case "dot":
-		ext := extFromFilename(fileName, []string{"svg", "png"}, "svg")
-		content, err = runShellCommand("dot", []string{getDotFormatFlag(ext)}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render graphviz")
-		}
-	case "plantuml":
-		ext := extFromFilename(fileName, []string{"svg", "png"}, "svg")
-		content, err = runShellCommand("plantuml", []string{getPlantUMLFormatFlag(ext), "-pipe"}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render plantuml")
+	line := r.Lines[r.ImageRelativeLineIndex]
+	var b strings.Builder
+	start := 0
+	for _, loc := range imageLinkStartRegexp.FindAllStringIndex(line, -1) {
+		if loc[0] < start {
+			continue
 		}
-	case "pikchr":
-		content, err = runShellCommand("pikchr", []string{"--svg-only", "-"}, strings.NewReader(codeBlockContent))
-		if err != nil {
-			return "", errors.Wrap(err, "render pikchr")
+		// Replace the prefix, between the start of the link and the
+		// filename, if the link is to one of the chunk's files
+		i := strings.Index(line[loc[1]:], stem+".")
+		if i < 0 || strings.ContainsAny(line[loc[1]:loc[1]+i], imageLinkEndChars) {
+			continue
 		}
-	default:
-		return "", fmt.Errorf("unsupported type: %s", r.Language)
+		b.WriteString(line[start:loc[1]])
+		b.WriteString(linkPrefix)
+		start = loc[1] + i
 	}
+	b.WriteString(line[start:])
+	r.Lines[r.ImageRelativeLineIndex] = b.String()
+}
 
-	outputFilePath := path.Join(outputDir, fileName)
-	f, err := os.Create(outputFilePath)
-	if err != nil {
-		return "", errors.Wrap(err, "create output file")
+func (r *Chunk) HashContent() string {
+	content := strings.Join(r.CodeBlockContent, "\n")
+	// Shared snippets are always hashed, so that changing one re-renders
+	// the code blocks that include it.
+	if r.IncludedBefore != "" {
+		content = injectContent(r.Language, content, r.IncludedBefore, "")
 	}
-	defer f.Close()
-	f.Write(content)
+	// So is the data, so that changing the data file re-renders the code block
+	if r.IncludedData != "" {
+		content = injectContent(r.Language, content, "", r.IncludedData)
+	}
+	// By default only the visible source is hashed, so changing the
+	// preludes alone does not force a re-render.
+	if config.Render.HashPreludes {
+		content = applyPreludes(r.Language, content)
+	}
+	// Layout options change the rendered image without changing the source
+	if args := r.RenderOptions.GraphvizArgs(); len(args) > 0 {
+		content += "\x00" + strings.Join(args, " ")
+	}
+	// So does the background, whether set on the code block or globally
+	if background := r.Background(); background != "" {
+		content += "\x00background=" + background
+	}
+	return fmt.Sprintf("%x", md5.Sum([]byte(content)))
+}
 
-	// Update the chunk's lines
-	image := buildMarkdownImage(fileName, linkPrefix)
-	if r.HasHashComment {
-		hashComment := buildHashComment(r.HashContent()[:8])
-		image = image + " " + hashComment
+// GraphvizArgs returns the arguments to pass to dot for the layout options.
+func (o RenderOptions) GraphvizArgs() []string {
+	var args []string
+	if o.Rankdir != "" {
+		args = append(args, "-Grankdir="+o.Rankdir)
+	}
+	if o.Size != "" {
+		args = append(args, "-Gsize="+o.Size)
 	}
-	r.Lines[r.ImageRelativeLineIndex] = image
+	return args
+}
 
-	return fileName, nil
+// OutputFilename returns the filename the chunk's image is rendered to.
+func (r *Chunk) OutputFilename() string {
+	if r.RenderOptions.Filename != "" {
+		// The extension of custom filenames is replaced by --output-ext
+		if config.Render.OutputExt != "" {
+			return strings.TrimSuffix(r.RenderOptions.Filename, filepath.Ext(r.RenderOptions.Filename)) + "." + config.Render.OutputExt
+		}
+		return r.RenderOptions.Filename
+	}
+	ext := r.DefaultExt()
+	if config.Render.OutputExt != "" {
+		ext = config.Render.OutputExt
+	} else if r.RenderOptions.Format != "" {
+		ext = r.RenderOptions.Format
+	}
+	return "render-" + r.FilenameHash() + "." + ext
 }
 
-func NewRenderCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "render",
-		Short: "Render code blocks in markdown files",
-		Long:  ``,
-		Args: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 {
-				return errors.New("no files specified as input")
-			}
-			return nil
-		},
-		RunE: renderCmd,
+// FilenameHash returns the hash used in auto-generated filenames, which is
+// the full hash unless --hash-length is set.
+func (r *Chunk) FilenameHash() string {
+	if config.Render.HashLength > 0 {
+		return r.HashContent()[:config.Render.HashLength]
 	}
-	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory to render code blocks to. If not specified, output will be rendered to the same directory as the input file.")
-	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "(required) Languages to render. Comma-separated. Supported languages: [dot, plantuml, pikchr].")
-	cmd.MarkFlagRequired("languages")
-	cmd.Flags().StringVar(&config.Render.LinkPrefix, "link-prefix", "", "Prefix to use when linking to rendered files")
-	return cmd
+	return r.HashContent()
 }
 
-func renderCmd(cmd *cobra.Command, args []string) error {
-	languages := strings.Split(config.Render.Languages, ",")
-	for _, v := range args {
-		err := processFile(v, languages, config.Render.OutputDir, config.Render.LinkPrefix)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("process file %s", v))
-		}
+// CommentHash returns the hash stored next to images with custom filenames,
+// which is the short hash unless --hash-length is set.
+func (r *Chunk) CommentHash() string {
+	if config.Render.HashLength > 0 {
+		return r.HashContent()[:config.Render.HashLength]
 	}
-	return nil
+	return r.HashContent()[:8]
 }
 
-func processFile(filePath string, types []string, outputDir string, linkPrefix string) error {
-	err := validateFileExists(filePath)
-	if err != nil {
-		return err
+// OutputExt returns the format to render the chunk in. If not set in the
+// render options, it is inferred from the output filename, falling back to
+// the default format of the language if the filename's extension is not one
+// of the accepted extensions.
+func (r *Chunk) OutputExt(acceptedExtensions []string) string {
+	if config.Render.OutputExt != "" {
+		return config.Render.OutputExt
+	}
+	if r.RenderOptions.Format != "" {
+		return r.RenderOptions.Format
 	}
+	return extFromFilename(r.OutputFilename(), acceptedExtensions, r.DefaultExt())
+}
 
-	// Read file into lines
-	f, err := os.Open(filePath)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("open file %s", filePath))
+// DefaultExt returns the format to render the chunk's language in when the
+// code block does not specify one.
+func (r *Chunk) DefaultExt() string {
+	if ext, ok := config.Render.DefaultExtensions[r.Language]; ok {
+		return ext
 	}
-	defer f.Close()
-	b, err := io.ReadAll(f)
-	if err != nil {
-		return errors.Wrap(err, fmt.Sprintf("read file %s", filePath))
+	if ext, ok := defaultExtensions[r.Language]; ok {
+		return ext
 	}
-	inputFileContent := string(b)
-	lines := strings.Split(inputFileContent, "\n")
+	return "svg"
+}
 
-	// Construct a lookup for O(1) access
-	typeLookup := make(map[string]bool)
-	for _, v := range types {
-		typeLookup[v] = true
+// OutputSubdir returns the subdirectory of the output directory the chunk's
+// image is rendered to. When sharding, auto-generated filenames are placed in
+// a subdirectory named after the first two characters of the hash.
+func (r *Chunk) OutputSubdir() string {
+	if config.Render.Shard && r.RenderOptions.Filename == "" {
+		return r.HashContent()[:2]
 	}
+	return ""
+}
 
-	// Split the file into chunks. A chunk can represent either a normal
-	// segment, or a renderable segment.
-	var chunks []*Chunk
-	var lastChunkIndex int
-	for idx, line := range lines {
-		// Skip ahead if these lines have been assigned a chunk already
-		if idx < lastChunkIndex {
-			continue
-		}
-		// Look for renderable code blocks
-		if strings.HasPrefix(line, "```") {
-			for k := range typeLookup {
-				if strings.HasPrefix(line, fmt.Sprintf("```%s render", k)) {
-					// Look at lines in and around the code
-					// block to determine the renderable chunk.
-					renderChunk, err := getRenderableChunk(lines, idx, k)
-					if err != nil {
-						return errors.Wrap(err, fmt.Sprintf("line %d: get renderable chunk", idx))
-					}
-					// Preceding lines not part of the renderable chunk are part of a
-					// normal chunk; construct one and add it to our list of chunks.
-					normalChunk := &Chunk{
-						StartLineIndex: lastChunkIndex,
-						EndLineIndex:   renderChunk.StartLineIndex - 1,
-					}
-					normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
-					chunks = append(chunks, normalChunk, renderChunk)
-					lastChunkIndex = renderChunk.EndLineIndex + 1
-					break
-				}
-			}
-		}
+func (r *Chunk) Render(outputDir string, linkPrefix string) (fileName string, err error) {
+	// Trim before the filename is derived from the hash of the content,
+	// so that the trimmed content is hashed on subsequent renders.
+	if config.Render.TrimTrailingWhitespace {
+		r.TrimTrailingWhitespace()
+		r.nameSourceFile()
 	}
-	if lastChunkIndex < len(lines) {
-		// The rest of the file is a normal chunk
-		normalChunk := &Chunk{
-			StartLineIndex: lastChunkIndex,
-			EndLineIndex:   len(lines) - 1,
-		}
-		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
-		chunks = append(chunks, normalChunk)
+	fileName = r.OutputFilename()
+
+	content, err := r.renderContent()
+	if err != nil {
+		return "", err
 	}
 
-	// Render the renderable chunks and join the chunks back into a file
-	var outputLines []string
-	for _, chunk := range chunks {
-		if chunk.ShouldRender() {
-			imageFileName, err := chunk.Render(outputDir, linkPrefix)
-			if err != nil {
-				return errors.Wrap(err, fmt.Sprintf("line %d: render chunk", chunk.CodeBlockIndex+1))
-			}
-			fmt.Printf("[%s:%d] Rendered %s\n", filePath, chunk.CodeBlockIndex+1, imageFileName)
+	// The image is written to disk only when the chunk is committed
+	subdir := r.OutputSubdir()
+	outputFilePath := path.Join(outputDir, subdir, fileName)
+	if !isWithinDir(outputDir, outputFilePath) {
+		return "", fmt.Errorf("output file %s is outside of the output directory", fileName)
+	}
+	// Each view of a structurizr workspace is rendered to its own image
+	if r.Language == "structurizr" {
+		content, err = r.splitViews(content, outputDir)
+		if err != nil {
+			return "", err
 		}
-		outputLines = append(outputLines, chunk.Lines...)
+	}
+	content, err = r.processImage(content, outputFilePath)
+	if err != nil {
+		return "", err
+	}
+	r.RenderedContent = content
+	r.OutputFilePath = outputFilePath
+	if r.HasFallback() {
+		fallbackContent, err := r.renderFallback()
+		if err != nil {
+			return "", errors.Wrap(err, "render fallback")
+		}
+		if config.Render.MaxImageBytes > 0 && int64(len(fallbackContent)) > config.Render.MaxImageBytes {
+			return "", fmt.Errorf("rendered fallback of %d bytes exceeds the maximum of %d bytes", len(fallbackContent), config.Render.MaxImageBytes)
+		}
+		r.FallbackContent = fallbackContent
+		r.FallbackFilePath = path.Join(outputDir, subdir, r.FallbackFilename())
+	}
+	if r.HasViewer() {
+		r.ViewerContent = buildViewerPage(fileName, content)
+		r.ViewerFilePath = path.Join(outputDir, subdir, r.ViewerFilename())
+	}
+	if subdir != "" {
+		linkPrefix = linkPrefix + subdir + "/"
 	}
 
-	// Write to disk if file has changed
-	outputContent := strings.Join(outputLines, "\n")
-	if inputFileContent != outputContent {
-		writer, err := os.OpenFile(filePath, os.O_WRONLY, 0666)
+	// Update the chunk's lines
+	if !config.Render.NoRewrite && r.HasBlockTemplate() {
+		r.Lines, err = r.buildBlockTemplateLines(fileName, linkPrefix)
 		if err != nil {
-			return errors.Wrap(err, "open file for writing")
+			return "", err
+		}
+	} else if !config.Render.NoRewrite {
+		r.Lines[r.ImageRelativeLineIndex] = strings.TrimRight(r.Format.BuildImageLine(r, fileName, linkPrefix), " \t")
+		if _, ok := r.Format.(MarkdownFormat); ok {
+			r.Lines[r.ImageRelativeLineIndex] = r.withCaption(r.Lines[r.ImageRelativeLineIndex])
 		}
-		defer writer.Close()
-		writer.WriteString(outputContent)
 	}
 
-	return nil
+	return fileName, nil
 }
 
-func getRenderableChunk(lines []string, codeBlockIndex int, language string) (*Chunk, error) {
-	chunk := &Chunk{}
-	chunk.IsRenderable = true
-	chunk.Language = language
-	chunk.CodeBlockIndex = codeBlockIndex
-
-	fence := lines[codeBlockIndex]
-	renderOptionsJSON := strings.TrimPrefix(fence, fmt.Sprintf("```%s render", language))
-	if strings.HasPrefix(renderOptionsJSON, "{") && strings.HasSuffix(renderOptionsJSON, "}") {
-		var renderOptions RenderOptions
-		err := json.Unmarshal([]byte(renderOptionsJSON), &renderOptions)
+// processImage applies the post-processing options to a rendered image, which
+// is written to outputFilePath.
+func (r *Chunk) processImage(content []byte, outputFilePath string) ([]byte, error) {
+	var err error
+	format := r.OutputFormat()
+	// Data is not an image, so only its size is checked
+	if format != "svg" && format != "png" {
+		err = r.checkImageSize(content)
 		if err != nil {
-			return nil, errors.Wrap(err, "unmarshal render options")
+			return nil, err
 		}
-		err = renderOptions.Validate()
+		return content, nil
+	}
+	if config.Render.ValidateSVG && format == "svg" {
+		err = verifySVG(content)
 		if err != nil {
-			return nil, errors.Wrap(err, "validate render options")
+			return nil, errors.Wrap(err, "invalid svg")
 		}
-		chunk.RenderOptions = renderOptions
-	} else {
-		chunk.RenderOptions = defaultRenderOptions
 	}
-
-	// Add a hash comment if a custom filename is set
-	if chunk.RenderOptions.Filename != "" {
-		chunk.HasHashComment = true
+	// Trimmed before anything is added around the content
+	if config.Render.Trim {
+		content, err = r.trimContent(content)
+		if err != nil {
+			return nil, err
+		}
 	}
-
-	var err error
-	renderTemplateManager := RenderTemplateManager{}
-	switch chunk.RenderOptions.Mode {
-	case "normal":
-		err = renderTemplateManager.Normal(lines, codeBlockIndex, chunk)
-	case "code-collapsed":
-		err = renderTemplateManager.CodeCollapsed(lines, codeBlockIndex, chunk)
-	case "image-collapsed":
-		err = renderTemplateManager.ImageCollapsed(lines, codeBlockIndex, chunk)
-	case "code-hidden":
-		err = renderTemplateManager.CodeHidden(lines, codeBlockIndex, chunk)
-	default:
-		return nil, errors.New("unsupported mode")
+	if maxWidth := r.MaxWidth(); maxWidth > 0 && format == "svg" {
+		content = fitSVGToWidth(content, maxWidth)
+	}
+	if background := r.Background(); background != "" && !r.hasNativeBackground() && format == "svg" {
+		content, err = addSVGBackground(content, background)
+		if err != nil {
+			return nil, errors.Wrap(err, "add background")
+		}
+	}
+	if config.Render.PortableSVG && format == "svg" {
+		dir, err := filepath.Abs(filepath.Dir(outputFilePath))
+		if err != nil {
+			return nil, errors.Wrap(err, "get output directory")
+		}
+		content = makeSVGPortable(content, dir)
+	}
+	if config.Render.Deterministic && format == "svg" {
+		content = makeSVGDeterministic(content)
 	}
+	if config.Render.Provenance {
+		content = r.addProvenance(content, format)
+	}
+	err = r.checkImageSize(content)
 	if err != nil {
-		return nil, errors.Wrap(err, "parse render template")
+		return nil, err
 	}
+	return content, nil
+}
 
-	return chunk, nil
+// checkImageSize guards against pathological diagrams bloating the
+// repository, with --max-image-bytes.
+func (r *Chunk) checkImageSize(content []byte) error {
+	if config.Render.MaxImageBytes > 0 && int64(len(content)) > config.Render.MaxImageBytes {
+		return fmt.Errorf("rendered image of %d bytes exceeds the maximum of %d bytes", len(content), config.Render.MaxImageBytes)
+	}
+	return nil
 }
 
-func runShellCommand(command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
-	cmd := exec.Command(command, args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = stdin
-	stdout := &bytes.Buffer{}
-	cmd.Stdout = stdout
-	err = cmd.Run()
-	return stdout.Bytes(), err
+// renderContent runs the renderer on the code block content, with the included
+// snippets and preludes injected, or reads its output from the cache.
+func (r *Chunk) renderContent() (content []byte, err error) {
+	codeBlockContent := r.RendererInput()
+	if config.Render.DebugDir != "" {
+		// Keep the exact input piped to the renderer, and its stderr if
+		// it fails, so that failures can be reproduced.
+		debugFilePath := path.Join(config.Render.DebugDir, r.HashContent()+"."+r.Language)
+		writeDebugFile(debugFilePath, []byte(codeBlockContent))
+		defer func() {
+			var cmdErr *CommandError
+			if errors.As(err, &cmdErr) {
+				writeDebugFile(path.Join(config.Render.DebugDir, r.HashContent()+".stderr"), cmdErr.Stderr)
+			}
+		}()
+	}
+
+	// The output of the renderer is cached by its input, so that identical
+	// code blocks are only rendered once
+	cacheKey := r.CacheKey(codeBlockContent)
+	content, ok := readCache(cacheKey)
+	if ok {
+		return content, nil
+	}
+	// Code blocks rendered ahead of time in a batch, see --batch-plantuml
+	content, ok = takeBatchedOutput(cacheKey)
+	if !ok {
+		content, err = r.runRenderer(codeBlockContent)
+		if err != nil {
+			return nil, err
+		}
+	}
+	writeCache(cacheKey, content)
+	return content, nil
 }
 
-func buildMarkdownImage(outputFilename, linkPrefix string) string {
-	return fmt.Sprintf("![%s](%s)", outputFilename, linkPrefix+outputFilename)
+// RendererInput returns the content piped to the renderer: the code block
+// content, with the included snippets and preludes injected.
+func (r *Chunk) RendererInput() string {
+	codeBlockContent := strings.Join(r.CodeBlockContent, "\n")
+	if r.IncludedBefore != "" {
+		codeBlockContent = injectContent(r.Language, codeBlockContent, r.IncludedBefore, "")
+	}
+	if r.IncludedData != "" {
+		codeBlockContent = injectContent(r.Language, codeBlockContent, "", r.IncludedData)
+	}
+	codeBlockContent = applyPreludes(r.Language, codeBlockContent)
+	if r.Language == "dot" && config.Render.WrapLabels > 0 {
+		codeBlockContent = wrapDotLabels(codeBlockContent, config.Render.WrapLabels)
+	}
+	return codeBlockContent
 }
 
-func buildHashComment(hash string) string {
-	return fmt.Sprintf("<!-- hash:%s -->", hash)
+// runRenderer renders the code block content with the renderer of the
+// chunk's language.
+func (r *Chunk) runRenderer(codeBlockContent string) ([]byte, error) {
+	if r.Language != "dot" && r.OutputExt([]string{"svg", "png", "json"}) == "json" {
+		return nil, fmt.Errorf("%s does not support the json format", r.Language)
+	}
+	// The background is added to SVGs afterwards, which is not possible
+	// for PNGs
+	if r.Background() != "" && !r.hasNativeBackground() && r.OutputFormat() == "png" {
+		return nil, fmt.Errorf("%s does not support a background color for the png format", r.Language)
+	}
+	switch r.Language {
+	case "dot":
+		ext := r.OutputFormat()
+		args := []string{getDotFormatFlag(ext)}
+		if maxWidth := r.MaxWidth(); maxWidth > 0 && ext == "png" {
+			// The size is in inches, at the default resolution of 96 dpi
+			// for bitmaps. Larger drawings are scaled down to fit, and the
+			// height is effectively unbounded.
+			args = append(args, "-Gdpi=96", fmt.Sprintf("-Gsize=%g,1000", float64(maxWidth)/96))
+		}
+		// Later arguments take precedence, so an explicit size overrides
+		// the maximum width
+		args = append(args, r.RenderOptions.GraphvizArgs()...)
+		if background := r.Background(); background != "" {
+			args = append(args, "-Gbgcolor="+background)
+		}
+		content, err := runShellCommandWithStderr(r.stderrOutput(), "dot", args, strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render graphviz")
+		}
+		return content, nil
+	case "plantuml":
+		ext := r.OutputFormat()
+		if maxWidth := r.MaxWidth(); maxWidth > 0 && ext == "png" {
+			codeBlockContent = injectPlantUMLScale(codeBlockContent, maxWidth)
+		}
+		if background := r.Background(); background != "" {
+			codeBlockContent = injectPlantUMLDirective(codeBlockContent, "skinparam backgroundColor "+background)
+		}
+		content, err := runShellCommandWithStderr(r.stderrOutput(), "plantuml", []string{getPlantUMLFormatFlag(ext), "-pipe"}, strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render plantuml")
+		}
+		return content, nil
+	case "pikchr":
+		if r.OutputExt([]string{"svg"}) != "svg" {
+			return nil, errors.New("pikchr only supports the svg format")
+		}
+		content, err := runShellCommandWithStderr(r.stderrOutput(), "pikchr", []string{"--svg-only", "-"}, strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render pikchr")
+		}
+		return content, nil
+	case "mscgen":
+		ext := r.OutputFormat()
+		content, err := runFileCommand(r.stderrOutput(), "mscgen", codeBlockContent, "msc", ext, func(inputPath, outputPath string) []string {
+			return []string{"-T", ext, "-o", outputPath, inputPath}
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "render mscgen")
+		}
+		return content, nil
+	case "math":
+		if r.OutputExt([]string{"svg"}) != "svg" {
+			return nil, errors.New("math only supports the svg format")
+		}
+		// tex2svg (from mathjax-node-cli) takes the equation as an
+		// argument rather than from stdin
+		content, err := runShellCommandWithStderr(r.stderrOutput(), "tex2svg", []string{"--", strings.TrimSpace(codeBlockContent)}, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "render math")
+		}
+		return content, nil
+	case "excalidraw":
+		ext := r.OutputFormat()
+		content, err := runFileCommand(r.stderrOutput(), "excalidraw-brute-export-cli", codeBlockContent, "excalidraw", ext, func(inputPath, outputPath string) []string {
+			return []string{"-i", inputPath, "-o", outputPath, "--format", ext}
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "render excalidraw")
+		}
+		return content, nil
+	case "erd":
+		ext := r.OutputFormat()
+		content, err := runShellCommandWithStderr(r.stderrOutput(), "erd", []string{"-f", ext}, strings.NewReader(codeBlockContent))
+		if err != nil {
+			return nil, errors.Wrap(err, "render erd")
+		}
+		return content, nil
+	case "railroad":
+		if r.OutputExt([]string{"svg"}) != "svg" {
+			return nil, errors.New("railroad only supports the svg format")
+		}
+		content, err := runFileCommand(r.stderrOutput(), "rr", codeBlockContent, "ebnf", "svg", func(inputPath, outputPath string) []string {
+			return []string{"-suppressebnf", "-out:" + outputPath, inputPath}
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "render railroad")
+		}
+		return content, nil
+	case "python-plot":
+		// The script is given the path to save the figure to, whose
+		// extension selects the format in e.g. matplotlib's savefig
+		ext := r.OutputFormat()
+		content, err := runFileCommand(r.stderrOutput(), "python3", codeBlockContent, "py", ext, func(inputPath, outputPath string) []string {
+			return []string{inputPath, outputPath}
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "render python-plot")
+		}
+		return content, nil
+	case "structurizr":
+		content, err := renderStructurizr(r.stderrOutput(), codeBlockContent, r.OutputFormat())
+		if err != nil {
+			return nil, errors.Wrap(err, "render structurizr")
+		}
+		return content, nil
+	case "chemfig":
+		if r.OutputExt([]string{"svg"}) != "svg" {
+			return nil, errors.New("chemfig only supports the svg format")
+		}
+		content, err := renderChemfig(r.stderrOutput(), codeBlockContent)
+		if err != nil {
+			return nil, errors.Wrap(err, "render chemfig")
+		}
+		return content, nil
+	default:
+		return nil, fmt.Errorf("unsupported type: %s", r.Language)
+	}
+}
+
+// stderrOutput returns where the stderr of the chunk's renderer is printed to.
+func (r *Chunk) stderrOutput() io.Writer {
+	if r.Log != nil {
+		return r.Log
+	}
+	return os.Stderr
+}
+
+// MaxWidth returns the maximum width of the chunk's rendered image, in pixels,
+// or 0 if there is none.
+func (r *Chunk) MaxWidth() int {
+	if r.RenderOptions.MaxWidth > 0 {
+		return r.RenderOptions.MaxWidth
+	}
+	return config.Render.MaxWidth
+}
+
+// OutputFormat returns the format the chunk is rendered in: json for dot code
+// blocks rendering data, and svg or png otherwise.
+func (r *Chunk) OutputFormat() string {
+	if r.Language == "dot" {
+		return r.OutputExt([]string{"svg", "png", "json"})
+	}
+	return r.OutputExt([]string{"svg", "png"})
+}
+
+// IsDataOutput checks if the chunk renders to data rather than an image, such
+// as the laid out graph of graphviz as JSON. Data is linked to instead of
+// displayed inline.
+func (r *Chunk) IsDataOutput() bool {
+	return r.Language == "dot" && r.OutputFormat() == "json"
+}
+
+// TrimTrailingWhitespace removes trailing whitespace from the chunk's lines,
+// and from the code block content.
+func (r *Chunk) TrimTrailingWhitespace() {
+	for i, v := range r.Lines {
+		r.Lines[i] = strings.TrimRight(v, " \t")
+	}
+	for i, v := range r.CodeBlockContent {
+		r.CodeBlockContent[i] = strings.TrimRight(v, " \t")
+	}
+}
+
+// CleanHashComments rewrites the image line to remove stale hash comments,
+// keeping only the current one.
+func (r *Chunk) CleanHashComments() {
+	line := r.Lines[r.ImageRelativeLineIndex]
+	line = strings.TrimRight(renderedHashRegexp.ReplaceAllString(line, ""), " ")
+	r.Lines[r.ImageRelativeLineIndex] = line + " " + buildHashComment(r.RenderedHash, r.RenderedRenderer)
+	r.HasStaleHashComments = false
+}
+
+// OutputFilePaths returns the paths of the files rendered from the chunk in
+// outputDir, whether or not it was rendered in this run: its image, and its
+// fallback, viewer page and views if any.
+func (r *Chunk) OutputFilePaths(outputDir string) []string {
+	dir := path.Join(outputDir, r.OutputSubdir())
+	filePaths := []string{path.Join(dir, r.OutputFilename())}
+	if r.HasFallback() {
+		filePaths = append(filePaths, path.Join(dir, r.FallbackFilename()))
+	}
+	if r.HasViewer() {
+		filePaths = append(filePaths, path.Join(dir, r.ViewerFilename()))
+	}
+	for _, v := range r.ViewFilenames() {
+		filePaths = append(filePaths, path.Join(dir, v))
+	}
+	return filePaths
+}
+
+// CommitFilePaths returns the paths of the files Commit writes.
+func (r *Chunk) CommitFilePaths() []string {
+	var filePaths []string
+	if r.HasPendingSourceFile && !config.Render.NoRewrite {
+		filePaths = append(filePaths, r.SourceFilePath)
+	}
+	filePaths = append(filePaths, r.OutputFilePath)
+	if r.FallbackFilePath != "" {
+		filePaths = append(filePaths, r.FallbackFilePath)
+	}
+	if r.ViewerFilePath != "" {
+		filePaths = append(filePaths, r.ViewerFilePath)
+	}
+	for _, v := range r.Views {
+		filePaths = append(filePaths, v.FilePath)
+	}
+	return filePaths
+}
+
+// Commit writes the rendered image, and the extracted source file if any, to
+// disk. It returns the paths of the files written.
+func (r *Chunk) Commit() (writtenFiles []string, err error) {
+	// The source file would not be linked to without rewriting
+	if r.HasPendingSourceFile && !config.Render.NoRewrite {
+		err = writeSourceFile(r.SourceFilePath, strings.Join(r.CodeBlockContent, "\n"))
+		if err != nil {
+			return writtenFiles, errors.Wrap(err, "write source file")
+		}
+		r.HasPendingSourceFile = false
+		writtenFiles = append(writtenFiles, r.SourceFilePath)
+	}
+
+	err = os.MkdirAll(filepath.Dir(r.OutputFilePath), 0755)
+	if err != nil {
+		return writtenFiles, errors.Wrap(err, "create output directory")
+	}
+	err = writeFileAtomic(r.OutputFilePath, r.RenderedContent, 0644)
+	if err != nil {
+		return writtenFiles, errors.Wrap(err, "write output file")
+	}
+	writtenFiles = append(writtenFiles, r.OutputFilePath)
+	if r.FallbackFilePath != "" {
+		err = writeFileAtomic(r.FallbackFilePath, r.FallbackContent, 0644)
+		if err != nil {
+			return writtenFiles, errors.Wrap(err, "write fallback file")
+		}
+		writtenFiles = append(writtenFiles, r.FallbackFilePath)
+	}
+	if r.ViewerFilePath != "" {
+		err = writeFileAtomic(r.ViewerFilePath, r.ViewerContent, 0644)
+		if err != nil {
+			return writtenFiles, errors.Wrap(err, "write viewer page")
+		}
+		writtenFiles = append(writtenFiles, r.ViewerFilePath)
+	}
+	for _, v := range r.Views {
+		err = writeFileAtomic(v.FilePath, v.Content, 0644)
+		if err != nil {
+			return writtenFiles, errors.Wrap(err, fmt.Sprintf("write view %s", v.Key))
+		}
+		writtenFiles = append(writtenFiles, v.FilePath)
+	}
+	return writtenFiles, nil
+}
+
+func NewRenderCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Render code blocks in markdown files",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && config.FilesFrom == "" && !config.PrintConfig {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: renderCmd,
+	}
+	addRenderFlags(cmd)
+	cmd.Flags().StringVar(&config.Render.Incremental, "incremental", "", "Path to a state file recording the files rendered by previous runs, so that files that have not changed since, along with the files they include and their images, are skipped without being parsed. Ignored with --manifest.")
+	cmd.Flags().StringVar(&config.Render.Lockfile, "lockfile", "", "Path to a lockfile recording the hash and image of each code block, e.g. render.lock, written after rendering")
+	cmd.Flags().BoolVar(&config.Render.Frozen, "frozen", false, fmt.Sprintf("Fail if a code block does not match the lockfile, instead of updating it. Defaults the lockfile to %s.", defaultLockfile))
+	cmd.Flags().BoolVar(&config.Render.WarnOnError, "warn-on-error", false, "If a code block fails to render, print a warning and leave it unchanged, and continue rendering the others without failing. Implies --partial-writes.")
+	cmd.Flags().BoolVar(&config.Render.Preview, "preview", false, "Open the images rendered from each file in the default viewer, in an HTML page embedding them. Ignored when not run in a terminal.")
+	cmd.Flags().BoolVar(&config.PrintConfig, "print-config", false, "Print the effective render config as JSON, after merging the config file and flags and applying defaults, and exit without rendering")
+	return cmd
+}
+
+// addRenderFlags adds the flags that control how code blocks are rendered.
+// They are shared by the commands that render code blocks.
+func addRenderFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.FilesFrom, "files-from", "", "Read the input files from this file, one per line, in addition to the ones given as arguments. Use - to read from stdin.")
+	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory to render code blocks to. If not specified, output will be rendered to the same directory as the input file.")
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "(required, unless set in the config file) Languages to render. Comma-separated. Supported languages: [dot, plantuml, pikchr, mscgen, math, excalidraw, railroad, erd, python-plot, chemfig, structurizr].")
+	cmd.Flags().StringVar(&config.Render.OnlyLanguages, "only-languages", "", "Subset of --languages to render on this run. Comma-separated. Code blocks of the other languages are left untouched.")
+	cmd.Flags().StringVar(&config.Render.Tags, "tags", "", "Only render code blocks with one of these tags, set with the tags option. Comma-separated. Other code blocks are left untouched.")
+	cmd.Flags().StringVar(&config.Render.Untagged, "untagged", "include", "Whether code blocks without tags are rendered with --tags: include, exclude")
+	cmd.Flags().StringVar(&config.Render.LinkPrefix, "link-prefix", "", "Prefix to use when linking to rendered files")
+	cmd.Flags().StringVar(&config.Render.Root, "root", "", "Project root. If specified, input files and other relative paths are resolved against it instead of the current directory, and a relative --link-prefix is a path from the root, made relative to each input file.")
+	cmd.Flags().StringVar(&config.Render.AllowList, "allow-list", "", "Renderer binaries that are allowed to run. Comma-separated. If not specified, all renderer binaries are allowed.")
+	cmd.Flags().StringVar(&config.Render.DenyList, "deny-list", "", "Renderer binaries that are not allowed to run. Comma-separated.")
+	cmd.Flags().StringVar(&config.Render.HashStyle, "hash-style", "comment", "How to store the hash of custom filenames. Supported styles: [comment, attribute]. The attribute style renders images as <img> tags with a data-hash attribute.")
+	cmd.Flags().IntVar(&config.Render.HashLength, "hash-length", 0, "Length of the hashes in auto-generated filenames and hash comments, 8 or 32. If not specified, filenames use 32 characters and hash comments use 8.")
+	cmd.Flags().BoolVar(&config.Render.Shard, "shard", false, "Place rendered files with auto-generated filenames into subdirectories named after the first two characters of their hash")
+	cmd.Flags().BoolVar(&config.Render.PortableSVG, "portable-svg", false, "Rewrite the absolute paths embedded in rendered SVGs, such as font paths and links to local files, so that they work when moved to another machine")
+	cmd.Flags().StringVar(&config.Render.Encoding, "encoding", "utf-8", "Character encoding of the input files, e.g. utf-16le, utf-16be, latin1 or windows-1252. Files are decoded before their code blocks are parsed, and encoded back when they are written.")
+	cmd.Flags().StringVar(&config.Render.NewlineEOF, "newline-eof", "preserve", "How the files are terminated when they are written: preserve, to end them like the input, ensure, to end them with a newline, strip, to remove the trailing newlines")
+	cmd.Flags().BoolVar(&config.Render.TrimTrailingWhitespace, "trim-trailing-whitespace", false, "Remove trailing whitespace from the lines of code blocks that are rendered")
+	cmd.Flags().BoolVar(&config.Render.ReplaceExistingImages, "replace-existing-images", false, "When a code block in the normal mode is rendered for the first time, remove the image right below it, e.g. manually exported before the code block was marked for rendering, so that the rendered image takes its place. Images of rendered code blocks are never removed.")
+	cmd.Flags().BoolVar(&config.Render.NoRewrite, "no-rewrite", false, "Only write rendered images, without modifying the input files. Code blocks are rendered if their image file does not exist.")
+	cmd.Flags().BoolVar(&config.Render.IfMissing, "if-missing", false, "Also render code blocks whose image file does not exist, even if the hash is unchanged")
+	cmd.Flags().BoolVar(&config.Render.HashRendererVersion, "hash-renderer-version", false, "Record the version of the renderer next to rendered images, so that upgrading a renderer forces a re-render")
+	cmd.Flags().BoolVar(&config.Render.Strict, "strict", false, "Fail on unknown keys in render options, instead of ignoring them")
+	cmd.Flags().BoolVar(&config.Render.HashPreludes, "hash-preludes", false, "Include the configured preludes and postludes when hashing code blocks, so that changing them forces a re-render")
+	cmd.Flags().BoolVar(&config.Render.ImageDimensions, "image-dimensions", false, "Include the width and height of rendered images in the image line, so that browsers reserve space for them. Markdown images become <img> tags.")
+	cmd.Flags().BoolVar(&config.Render.Deterministic, "deterministic", false, "Strip the content of rendered SVGs that changes between renders of the same source, such as comments with timestamps and generated IDs, so that re-rendering an unchanged code block yields the same bytes")
+	cmd.Flags().BoolVar(&config.Render.Provenance, "provenance", false, "Record the file and line of the code block each image was rendered from in the image, as a <!-- source: docs/arch.md:42 --> comment in SVGs and a Source text chunk in PNGs, so that images collected into a shared directory can be traced back to their source")
+	cmd.Flags().BoolVar(&config.Render.Trim, "trim", false, "Trim the whitespace around rendered images. The viewBox of SVGs is tightened to their content, and PNGs are trimmed with ImageMagick if it is installed.")
+	cmd.Flags().StringVar(&config.Render.OutputExt, "output-ext", "", "Render every code block to this format, svg or png, overriding the format and the extension of the filename set in the render options, e.g. for a PDF export build")
+	cmd.Flags().BoolVar(&config.Render.AltFromComment, "alt-from-comment", false, "Use the comment on the first line of a code block, e.g. // Architecture overview, as the alt text of its image if it has no alt option, instead of the filename")
+	cmd.Flags().StringVar(&config.Render.Locale, "locale", "", "Locale of the alt text to write for images, from the alt_locales option of each code block. Code blocks without alt text for the locale use the alt option, or the filename.")
+	cmd.Flags().StringVar(&config.Render.Background, "background", "", "Background color of rendered images, as a hex color like #1e1e1e or a color name, instead of a transparent or white background. Can be overridden per code block with the background option.")
+	cmd.Flags().IntVar(&config.Render.MaxWidth, "max-width", 0, "Maximum width of rendered images, in pixels. Wider SVGs are scaled down by setting their width, and dot and plantuml PNGs are rendered at a smaller scale. Can be overridden per code block with the max_width option.")
+	cmd.Flags().BoolVar(&config.Render.Picture, "picture", false, "Also render SVG images as PNG, and link to them with a <picture> element that falls back to the PNG where SVG is not supported")
+	cmd.Flags().IntVar(&config.Render.WrapLabels, "wrap-labels", 0, "Wrap the label attributes of dot code blocks that are longer than this many characters, by inserting line breaks between words. If not specified, labels are not wrapped.")
+	cmd.Flags().StringVar(&config.Render.OutputStyle, "output-style", "markdown", "Syntax of the line linking to a rendered image. Supported styles: [markdown, hugo, docusaurus].")
+	cmd.Flags().StringVar(&config.Render.ImageTemplate, "image-template", "", "Template for the line linking to a rendered image, instead of the markdown image syntax. Supports the {filename}, {link}, and {hash} placeholders. Takes precedence over --output-style.")
+	cmd.Flags().StringVar(&config.Render.ImageRegexp, "image-regexp", "", "Regexp to detect previously rendered images when using --image-template, with a named capture group for the filename or link, and optionally the hash. If not specified, it is derived from the template.")
+	cmd.Flags().StringVar(&config.Render.TemplateFile, "template-file", "", "Path to a Go text/template building the lines that replace each code block, in place of the template of its mode. The template receives the .Filename, .Link, .Alt, .Caption, .Hash, .Language, .Mode, .Code, .CodeBlock, and .Image of the code block, and must include .CodeBlock. Markdown only.")
+	cmd.Flags().StringVar(&config.Render.InputFormat, "input-format", "auto", "Format of the input files. Supported formats: [auto, markdown, asciidoc]. The auto format detects AsciiDoc files by their .adoc, .asciidoc, or .asc extension.")
+	cmd.Flags().StringVar(&config.Render.Directive, "directive", "render", "Keyword in the opening fence that marks a code block for rendering")
+	cmd.Flags().StringArrayVar(&config.Render.RenderEnv, "render-env", nil, "Environment variable to set for renderer commands, in the form KEY=VALUE. Can be specified multiple times.")
+	cmd.Flags().BoolVar(&config.Render.VerifyOutputs, "verify-outputs", false, "Check that the images of up to date code blocks are complete, e.g. not truncated by a crashed render, and re-render them if not. SVGs must be well-formed, PNGs must decode, and JSON must be valid.")
+	cmd.Flags().BoolVar(&config.Render.ValidateSVG, "validate-svg", false, "Check that each rendered SVG is well-formed XML with an svg root element, and fail the code block if not, e.g. when a renderer outputs an error page or partial output as if it succeeded")
+	cmd.Flags().BoolVar(&config.Render.VerifyLinks, "verify-links", false, "After rendering, check that the links to rendered files in each file resolve to existing files, from the file's directory, or from the project root for absolute paths. Broken links are reported with their location, and fail the render.")
+	cmd.Flags().BoolVar(&config.Render.BatchPlantUML, "batch-plantuml", false, "Render the plantuml code blocks of each file in a single plantuml process, instead of one per code block, to avoid paying for the JVM startup each time. If the batch fails, the code blocks are rendered one by one.")
+	cmd.Flags().IntVar(&config.Render.Jobs, "jobs", runtime.NumCPU(), "Maximum number of files to process concurrently, and of renderer commands to run concurrently across all files. Files are written in the order they are given in.")
+	cmd.Flags().IntVar(&config.Render.JobsPerFile, "jobs-per-file", 1, "Maximum number of code blocks to render concurrently within a file")
+	cmd.Flags().BoolVar(&config.Render.StableOrder, "stable-order", false, "Print the stderr of the renderers of code blocks rendered concurrently in the order of the code blocks, once all code blocks of the file are rendered, instead of as it happens, so that the output is deterministic")
+	cmd.Flags().BoolVarP(&config.Render.Quiet, "quiet", "q", false, "Do not print progress or rendered files. Warnings and errors are still printed.")
+	cmd.Flags().StringVar(&config.Render.CacheDir, "cache-dir", "", "Directory to cache the output of renderers in, by their input, so that identical code blocks are only rendered once. Can be shared by concurrent runs.")
+	cmd.Flags().StringVar(&config.Render.Output, "output", "", "Path to write the rendered content of a remote input file to. If not specified, it is written to stdout.")
+	cmd.Flags().StringVar(&config.Render.Manifest, "manifest", "", "Path to write a JSON manifest of the rendered images to, with the file, line, language, and hash of the code block each was rendered from")
+	cmd.Flags().BoolVar(&config.Render.LFSReport, "lfs-report", false, "After rendering, print which generated files are binary and which are text, with a suggested .gitattributes storing the binary ones in Git LFS")
+	cmd.Flags().StringVar(&config.Render.Gitattributes, "gitattributes", "", "Path to write a suggested .gitattributes to after rendering, storing the binary generated files in Git LFS and the text ones as regular text")
+	cmd.Flags().StringVar(&config.Render.PostRenderHook, "post-render-hook", "", "Command to run on each rendered file, e.g. to optimize or upload it, with the file's path as its last argument. Run by the shell. A failing hook fails the render.")
+	cmd.Flags().StringVar(&config.Render.DebugDir, "debug-dir", "", "Directory to write the exact input piped to each renderer to, along with its stderr if it fails")
+	cmd.Flags().Int64Var(&config.Render.MaxFileSize, "max-file-size", 0, "Skip input files larger than this size, in bytes. If not specified, there is no limit.")
+	cmd.Flags().Int64Var(&config.Render.MaxImageBytes, "max-image-bytes", 0, "Fail if a rendered image is larger than this size, in bytes, instead of writing it. If not specified, there is no limit.")
+	cmd.Flags().BoolVar(&config.Render.PartialWrites, "partial-writes", false, "If a code block fails to render, still write the code blocks in the file that rendered successfully")
+	cmd.Flags().StringVar(&config.Render.SourceDir, "source-dir", "diagrams", "Directory, relative to the input file, to extract code blocks to in the external mode")
+}
+
+// prepareRender validates the render config and sets up the state shared by
+// all renders.
+func prepareRender() error {
+	if config.Render.Languages == "" {
+		return errors.New("no languages specified, set --languages or render.languages in the config file")
+	}
+	config.Render.OutputDir = resolveRootPath(config.Render.OutputDir)
+	config.Render.CacheDir = resolveRootPath(config.Render.CacheDir)
+	config.Render.DebugDir = resolveRootPath(config.Render.DebugDir)
+	config.Render.Manifest = resolveRootPath(config.Render.Manifest)
+	switch config.Render.HashLength {
+	case 0, 8, 32:
+	default:
+		return fmt.Errorf("unsupported hash length: %d, expected 8 or 32", config.Render.HashLength)
+	}
+	switch config.Render.HashStyle {
+	case "comment", "attribute":
+	default:
+		return fmt.Errorf("unsupported hash style: %s", config.Render.HashStyle)
+	}
+	template := config.Render.ImageTemplate
+	if template == "" && config.Render.OutputStyle != "markdown" {
+		var ok bool
+		template, ok = outputStyleTemplates[config.Render.OutputStyle]
+		if !ok {
+			return fmt.Errorf("unsupported output style: %s", config.Render.OutputStyle)
+		}
+	}
+	if config.Render.TemplateFile != "" {
+		var err error
+		blockTemplate, err = loadBlockTemplate(config.Render.TemplateFile)
+		if err != nil {
+			return err
+		}
+	}
+	if template != "" {
+		var err error
+		imageTemplate, err = NewImageTemplate(template, config.Render.ImageRegexp)
+		if err != nil {
+			return errors.Wrap(err, "parse image template")
+		}
+	}
+	if err := validateBackground(config.Render.Background); err != nil {
+		return err
+	}
+	switch config.Render.OutputExt {
+	case "", "svg", "png":
+	default:
+		return fmt.Errorf("unsupported output extension: %s", config.Render.OutputExt)
+	}
+	var err error
+	inputEncoding, err = lookupEncoding(config.Render.Encoding)
+	if err != nil {
+		return err
+	}
+	switch config.Render.NewlineEOF {
+	case "preserve", "ensure", "strip":
+	default:
+		return fmt.Errorf("unsupported newline at end of file policy: %s, expected preserve, ensure, or strip", config.Render.NewlineEOF)
+	}
+	switch config.Render.Untagged {
+	case "include", "exclude":
+	default:
+		return fmt.Errorf("unsupported untagged policy: %s, expected include or exclude", config.Render.Untagged)
+	}
+	for language, ext := range config.Render.DefaultExtensions {
+		switch ext {
+		case "svg", "png", "json":
+		default:
+			return fmt.Errorf("unsupported default extension for %s: %s", language, ext)
+		}
+	}
+	for _, v := range config.Render.RenderEnv {
+		if !strings.Contains(v, "=") || strings.HasPrefix(v, "=") {
+			return fmt.Errorf("invalid render env %s, expected KEY=VALUE", v)
+		}
+	}
+	if config.Render.Jobs > 0 {
+		commandSemaphore = make(chan struct{}, config.Render.Jobs)
+	}
+	return nil
+}
+
+func renderCmd(cmd *cobra.Command, args []string) error {
+	err := prepareRender()
+	if err != nil {
+		return err
+	}
+	// Failed code blocks are left unchanged, like with partial writes
+	if config.Render.WarnOnError {
+		config.Render.PartialWrites = true
+	}
+	if config.Render.Frozen && config.Render.Lockfile == "" {
+		config.Render.Lockfile = defaultLockfile
+	}
+	config.Render.Lockfile = resolveRootPath(config.Render.Lockfile)
+	// The config is printed once validated and defaulted, as it is used
+	if config.PrintConfig {
+		return printConfig()
+	}
+	args, err = inputFiles(args)
+	if err != nil {
+		return err
+	}
+	// The manifest and the generated file report list every file, so none
+	// can be skipped
+	if config.Render.Incremental != "" && config.Render.Manifest == "" && !isGeneratedFileReportEnabled() {
+		incrementalState, err = loadIncrementalState(config.Render.Incremental)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Render.Output != "" && (len(args) != 1 || !isRemoteInput(args[0])) {
+		return errors.New("--output can only be used with a single remote input file")
+	}
+	for _, v := range args {
+		if isRemoteInput(v) && config.Render.Output == "" {
+			// Keep stdout for the rendered content
+			logOutput = os.Stderr
+			break
+		}
+	}
+	if config.Render.Lockfile != "" {
+		lockfile, err = loadLockfile(config.Render.Lockfile)
+		if err != nil {
+			return err
+		}
+	}
+	languages := strings.Split(config.Render.Languages, ",")
+	progress = NewProgress(len(args))
+	defer progress.Done()
+	stopHandlingInterrupts := handleInterrupts()
+	defer stopHandlingInterrupts()
+	err = processFiles(args, languages)
+	if err != nil {
+		return err
+	}
+	if incrementalState != nil {
+		err := incrementalState.Write(config.Render.Incremental)
+		if err != nil {
+			return err
+		}
+	}
+	// The lockfile is only read when frozen, like npm ci
+	if lockfile != nil && !config.Render.Frozen {
+		err := lockfile.Write(config.Render.Lockfile)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Render.Manifest != "" {
+		err := writeManifest(config.Render.Manifest)
+		if err != nil {
+			return err
+		}
+	}
+	if config.Render.LFSReport {
+		printLFSReport()
+	}
+	if config.Render.Gitattributes != "" {
+		err := writeGitattributes(config.Render.Gitattributes)
+		if err != nil {
+			return err
+		}
+	}
+	if brokenLinkCount > 0 {
+		return fmt.Errorf("%d broken links to rendered files", brokenLinkCount)
+	}
+	return nil
+}
+
+// inputFiles returns the files to process: the files given as arguments,
+// followed by the files listed in --files-from.
+func inputFiles(args []string) ([]string, error) {
+	if config.FilesFrom == "" {
+		return args, nil
+	}
+	var b []byte
+	var err error
+	if config.FilesFrom == "-" {
+		b, err = io.ReadAll(os.Stdin)
+	} else {
+		b, err = os.ReadFile(config.FilesFrom)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read files from")
+	}
+	files := append([]string{}, args...)
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// RenderedFile is the result of rendering a file in memory.
+type RenderedFile struct {
+	InputContent  string
+	OutputContent string
+	Chunks        []*Chunk
+	// Where the code block of each renderable chunk is located in the
+	// output content
+	OutputCodeBlockIndexes map[*Chunk]int
+	// Chunks that rendered successfully, whose images are not yet written
+	RenderedChunks []*Chunk
+	// With partial writes, the first error of the chunks that failed to
+	// render
+	RenderErr error
+}
+
+// processFiles processes the files concurrently, bounded by --jobs. Files are
+// rendered as soon as a job is free, but written one at a time in the order
+// they are given in, so that the messages printed and the files written are
+// the same as if the files were processed one by one. Once a file fails, the
+// files after it are not written, and no more files are started.
+func processFiles(args []string, languages []string) error {
+	jobs := config.Render.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(args))
+	// Each file waits for its turn to be written, which the file before it
+	// passes on once it is written or skipped
+	turns := make([]chan struct{}, len(args)+1)
+	for i := range turns {
+		turns[i] = make(chan struct{})
+	}
+	close(turns[0])
+	var hasFailed int32
+	// Only accessed in turn, so that files after a failed one are skipped
+	isSkipped := false
+	semaphore := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, v := range args {
+		semaphore <- struct{}{}
+		if isInterrupted() {
+			<-semaphore
+			errs[i] = errInterrupted
+			break
+		}
+		if atomic.LoadInt32(&hasFailed) == 1 {
+			<-semaphore
+			break
+		}
+		progress.Update(i+1, v)
+		wg.Add(1)
+		go func(i int, v string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			filePath := resolveRootPath(v)
+			isUnchanged := incrementalState != nil && !isRemoteInput(filePath) && incrementalState.IsUnchanged(filePath)
+			var renderedFile *RenderedFile
+			var err error
+			if !isUnchanged {
+				renderedFile, err = renderFile(filePath, languages, config.Render.OutputDir, linkPrefixForFile(filePath))
+			}
+			if err != nil {
+				atomic.StoreInt32(&hasFailed, 1)
+			}
+
+			<-turns[i]
+			defer close(turns[i+1])
+			if isSkipped {
+				return
+			}
+			// Unchanged files keep their entries in the lockfile
+			if err == nil && !isUnchanged {
+				err = writeRenderedFile(filePath, renderedFile, config.Render.OutputDir)
+			}
+			if err != nil {
+				atomic.StoreInt32(&hasFailed, 1)
+				isSkipped = true
+				errs[i] = err
+				if _, ok := err.(*SourceError); !ok {
+					errs[i] = errors.Wrap(err, fmt.Sprintf("process file %s", v))
+				}
+			}
+		}(i, v)
+	}
+	wg.Wait()
+	// Report the error of the first file that failed, as if the files were
+	// processed one by one. SourceErrors already identify the file.
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRenderedFile writes the images rendered from a file and its updated
+// content, and records the file, e.g. in the manifest.
+func writeRenderedFile(filePath string, renderedFile *RenderedFile, outputDir string) error {
+	// Skipped files keep their entries in the lockfile
+	if renderedFile == nil {
+		return nil
+	}
+
+	// Commit the rendered images. If any fail to be written, remove the
+	// ones created so far to avoid leaving orphaned files behind. Files that
+	// existed before the render, e.g. images being re-rendered, are left as
+	// they are rather than deleted.
+	var writtenFiles []string
+	existedBefore := make(map[string]bool)
+	for _, chunk := range renderedFile.RenderedChunks {
+		for _, v := range chunk.CommitFilePaths() {
+			if _, ok := existedBefore[v]; !ok {
+				_, err := os.Stat(v)
+				existedBefore[v] = err == nil
+			}
+		}
+		files, err := chunk.Commit()
+		writtenFiles = append(writtenFiles, files...)
+		if err != nil {
+			if !config.Render.PartialWrites {
+				var createdFiles []string
+				isCreated := make(map[string]bool)
+				for _, v := range writtenFiles {
+					// Identical code blocks may share a file
+					if !existedBefore[v] && !isCreated[v] {
+						createdFiles = append(createdFiles, v)
+						isCreated[v] = true
+					}
+				}
+				removeFiles(createdFiles)
+			}
+			return errors.Wrap(err, fmt.Sprintf("line %d: commit chunk", chunk.CodeBlockIndex+1))
+		}
+		logf("[%s:%d] Rendered %s\n", filePath, chunk.CodeBlockIndex+1, path.Base(chunk.OutputFilePath))
+	}
+	if isGeneratedFileReportEnabled() {
+		err := recordGeneratedFiles(renderedFile.GeneratedFilePaths(outputDir))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Remote input files cannot be edited in place, so their content is
+	// always written out. Otherwise, write to disk if file has changed,
+	// unless input files are not rewritten.
+	if isRemoteInput(filePath) {
+		err := writeRemoteOutput(renderedFile.OutputContent)
+		if err != nil {
+			return err
+		}
+	} else if !config.Render.NoRewrite && renderedFile.InputContent != renderedFile.OutputContent {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			return errors.Wrap(err, "stat file")
+		}
+		b, err := encodeOutput(renderedFile.OutputContent)
+		if err != nil {
+			return err
+		}
+		err = writeFileAtomic(filePath, b, fileInfo.Mode().Perm())
+		if err != nil {
+			return errors.Wrap(err, "write file")
+		}
+	}
+
+	if config.Render.VerifyLinks && !isRemoteInput(filePath) {
+		verifyLinks(filePath, renderedFile)
+	}
+
+	// Run the hook once everything is written, so that it never sees a
+	// file that is removed because a later one failed to be written
+	if config.Render.PostRenderHook != "" {
+		for _, chunk := range renderedFile.RenderedChunks {
+			err := runPostRenderHook(chunk.OutputFilePath)
+			if err != nil {
+				return errors.Wrap(err, fmt.Sprintf("line %d: post-render hook", chunk.CodeBlockIndex+1))
+			}
+		}
+	}
+
+	if config.Render.Preview {
+		previewRenderedFile(filePath, renderedFile)
+	}
+	if config.Render.Manifest != "" {
+		addManifestEntries(filePath, renderedFile, outputDir)
+	}
+	if lockfile != nil && renderedFile.RenderErr == nil {
+		lockfile.Record(filePath, renderedFile.Chunks, outputDir)
+	}
+	if incrementalState != nil && renderedFile.RenderErr == nil && !isRemoteInput(filePath) {
+		incrementalState.Record(filePath, renderedFile, outputDir)
+	}
+
+	// The failures were reported as warnings. They are rendered again on
+	// the next run, since the file is not recorded as rendered.
+	if config.Render.WarnOnError && errors.Cause(renderedFile.RenderErr) != errInterrupted {
+		return nil
+	}
+	return renderedFile.RenderErr
+}
+
+// renderFile renders the code blocks of a file in memory, without writing
+// anything to disk. It returns nil if the file was skipped.
+func renderFile(filePath string, types []string, outputDir string, linkPrefix string) (*RenderedFile, error) {
+	// Guard against reading an unexpectedly large file into memory
+	if config.Render.MaxFileSize > 0 {
+		fileInfo, err := os.Stat(filePath)
+		if err == nil && fileInfo.Size() > config.Render.MaxFileSize {
+			warnf("[%s] Skipped, file size of %d bytes exceeds the maximum of %d bytes\n", filePath, fileInfo.Size(), config.Render.MaxFileSize)
+			return nil, nil
+		}
+	}
+
+	inputFileContent, err := readInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(inputFileContent, "\n")
+	// The lines of the chunks share their backing array with lines, and are
+	// edited in place, e.g. to clean stale hash comments. Chunks that are left
+	// untouched are written back from this copy.
+	originalLines := append([]string{}, lines...)
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		chunk.FilePath = filePath
+	}
+	err = claimOutputFiles(filePath, chunks, outputDir)
+	if err != nil {
+		return nil, err
+	}
+	if lockfile != nil && config.Render.Frozen {
+		err = lockfile.Check(filePath, chunks, outputDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Render the renderable chunks. Rendered images are held in memory so
+	// that nothing is written unless the whole file renders successfully.
+	var chunksToRender []*Chunk
+	isUntouched := make(map[*Chunk]bool)
+	for _, chunk := range chunks {
+		if chunk.IsRenderable && config.Render.OnlyLanguages != "" && !listContains(config.Render.OnlyLanguages, chunk.Language) {
+			isUntouched[chunk] = true
+			continue
+		}
+		if chunk.IsRenderable && !chunk.IsSelectedByTags() {
+			isUntouched[chunk] = true
+			continue
+		}
+		if chunk.IsRenderable && config.Render.NoRewrite {
+			isUntouched[chunk] = true
+		}
+		if !chunk.ShouldRender(outputDir) {
+			if chunk.HasStaleHashComments {
+				chunk.CleanHashComments()
+			}
+			if chunk.IsRenderable {
+				chunk.relinkImageLine(linkPrefix)
+				chunk.updateCaption()
+			}
+			continue
+		}
+		chunksToRender = append(chunksToRender, chunk)
+	}
+	if config.Render.BatchPlantUML {
+		batchedCacheKeys := batchRenderPlantUML(chunksToRender)
+		defer clearBatchedOutputs(batchedCacheKeys)
+	}
+	renderErrs := renderChunks(chunksToRender, outputDir, linkPrefix)
+
+	var renderedChunks []*Chunk
+	var renderErr error
+	isRendered := make(map[*Chunk]bool)
+	for i, chunk := range chunksToRender {
+		err := renderErrs[i]
+		if err == errRenderSkipped {
+			continue
+		}
+		if err != nil {
+			err = chunk.LocateRenderError(filePath, err)
+			if config.Render.WarnOnError && err != errInterrupted {
+				warnf("[%s:%d] Failed to render, left unchanged: %s\n", filePath, chunk.CodeBlockIndex+1, err)
+			}
+			if _, ok := err.(*SourceError); !ok {
+				err = errors.Wrap(err, fmt.Sprintf("line %d: render chunk", chunk.CodeBlockIndex+1))
+			}
+			if !config.Render.PartialWrites {
+				return nil, err
+			}
+			// Report the first error, but continue rendering the rest
+			if renderErr == nil {
+				renderErr = err
+			}
+			continue
+		}
+		renderedChunks = append(renderedChunks, chunk)
+		isRendered[chunk] = true
+	}
+
+	// Join the chunks back into a file. Chunks of languages not selected
+	// for this run, chunks when not rewriting, and when writing partially,
+	// chunks that were not rendered, are left as they were.
+	var outputLines []string
+	outputCodeBlockIndexes := make(map[*Chunk]int)
+	for _, chunk := range chunks {
+		chunkLines := chunk.Lines
+		if isUntouched[chunk] || (renderErr != nil && chunk.IsRenderable && !isRendered[chunk]) {
+			chunkLines = originalLines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+		}
+		if chunk.IsRenderable {
+			for i, line := range chunkLines {
+				if _, _, ok := format.MatchRenderableBlock(line, nil); ok {
+					outputCodeBlockIndexes[chunk] = len(outputLines) + i
+					break
+				}
+			}
+		}
+		outputLines = append(outputLines, chunkLines...)
+	}
+	outputContent := strings.Join(outputLines, "\n")
+	if !config.Render.NoRewrite {
+		outputContent = applyNewlineEOF(outputContent)
+	}
+
+	return &RenderedFile{
+		InputContent:           inputFileContent,
+		OutputContent:          outputContent,
+		Chunks:                 chunks,
+		OutputCodeBlockIndexes: outputCodeBlockIndexes,
+		RenderedChunks:         renderedChunks,
+		RenderErr:              renderErr,
+	}, nil
+}
+
+// applyNewlineEOF terminates the content of a file according to
+// --newline-eof. Files with CRLF line endings are ended with CRLF.
+func applyNewlineEOF(content string) string {
+	switch config.Render.NewlineEOF {
+	case "ensure":
+		if content == "" || strings.HasSuffix(content, "\n") {
+			return content
+		}
+		if strings.Contains(content, "\r\n") {
+			return content + "\r\n"
+		}
+		return content + "\n"
+	case "strip":
+		return strings.TrimRight(content, "\r\n")
+	default:
+		return content
+	}
+}
+
+// Returned for chunks that were not rendered, because a previous chunk
+// failed to render
+var errRenderSkipped = errors.New("render skipped")
+
+// renderChunks renders the chunks concurrently, bounded by --jobs-per-file.
+// It returns the error of rendering each chunk. Unless partial writes are
+// enabled, chunks not yet started are skipped once any chunk fails. Chunks
+// not yet started when interrupted fail with errInterrupted.
+func renderChunks(chunks []*Chunk, outputDir string, linkPrefix string) []error {
+	jobs := config.Render.JobsPerFile
+	if jobs < 1 {
+		jobs = 1
+	}
+	errs := make([]error, len(chunks))
+	semaphore := make(chan struct{}, jobs)
+	var hasFailed int32
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		// Messages are printed in the order of the code blocks once all
+		// are rendered, rather than as they happen. The messages of a batch
+		// rendered ahead of time are kept.
+		if config.Render.StableOrder && chunk.Log == nil {
+			chunk.Log = &LogBuffer{}
+		}
+		semaphore <- struct{}{}
+		if isInterrupted() {
+			<-semaphore
+			errs[i] = errInterrupted
+			continue
+		}
+		if !config.Render.PartialWrites && atomic.LoadInt32(&hasFailed) == 1 {
+			<-semaphore
+			errs[i] = errRenderSkipped
+			continue
+		}
+		wg.Add(1)
+		go func(i int, chunk *Chunk) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			_, errs[i] = chunk.Render(outputDir, linkPrefix)
+			if errs[i] != nil {
+				atomic.StoreInt32(&hasFailed, 1)
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+	for _, chunk := range chunks {
+		if chunk.Log != nil {
+			chunk.Log.Flush()
+			chunk.Log = nil
+		}
+	}
+	return errs
+}
+
+// readInputFile reads the content of an input file.
+func readInputFile(filePath string) (string, error) {
+	if isRemoteInput(filePath) {
+		return fetchRemoteInput(filePath)
+	}
+	err := validateFileExists(filePath)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("open file %s", filePath))
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("read file %s", filePath))
+	}
+	return decodeInput(b)
+}
+
+// splitChunks splits the file into chunks. A chunk can represent either a
+// normal segment, or a renderable segment. With --warn-on-error, code blocks
+// that cannot be parsed, e.g. because of invalid options or a missing
+// included file, are left in a normal segment.
+func splitChunks(format InputFormat, lines []string, types []string, filePath string, sidecar Sidecar) ([]*Chunk, error) {
+	fileDir := inputFileDir(filePath)
+	// Construct a lookup for O(1) access. If no types are given, any
+	// language matches.
+	var typeLookup map[string]bool
+	if types != nil {
+		typeLookup = make(map[string]bool)
+		for _, v := range types {
+			typeLookup[v] = true
+		}
+	}
+
+	var chunks []*Chunk
+	var lastChunkIndex int
+	var blockNumber int
+	for idx, line := range lines {
+		// Skip ahead if these lines have been assigned a chunk already
+		if idx < lastChunkIndex {
+			continue
+		}
+		// Code blocks of all languages are numbered, so that the numbers
+		// in the sidecar file do not depend on the languages rendered
+		if _, _, ok := format.MatchRenderableBlock(line, nil); !ok {
+			continue
+		}
+		blockNumber++
+		// Look for renderable code blocks
+		language, renderOptionsJSON, ok := format.MatchRenderableBlock(line, typeLookup)
+		if !ok {
+			continue
+		}
+		// Look at lines in and around the code block to determine the
+		// renderable chunk.
+		renderChunk, err := getRenderableChunk(format, lines, idx, language, renderOptionsJSON, fileDir, sidecar.Options(blockNumber))
+		if err != nil && config.Render.WarnOnError {
+			warnf("[%s:%d] Failed to render, left unchanged: %s\n", filePath, idx+1, err)
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("line %d: get renderable chunk", idx+1))
+		}
+		// Preceding lines not part of the renderable chunk are part of a
+		// normal chunk; construct one and add it to our list of chunks.
+		normalChunk := &Chunk{
+			StartLineIndex: lastChunkIndex,
+			EndLineIndex:   renderChunk.StartLineIndex - 1,
+		}
+		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
+		chunks = append(chunks, normalChunk, renderChunk)
+		lastChunkIndex = renderChunk.EndLineIndex + 1
+	}
+	if lastChunkIndex < len(lines) {
+		// The rest of the file is a normal chunk
+		normalChunk := &Chunk{
+			StartLineIndex: lastChunkIndex,
+			EndLineIndex:   len(lines) - 1,
+		}
+		normalChunk.Lines = lines[normalChunk.StartLineIndex : normalChunk.EndLineIndex+1]
+		chunks = append(chunks, normalChunk)
+	}
+	return chunks, nil
+}
+
+// getRenderableChunk parses the code block at codeBlockIndex. Its render
+// options are applied over the base options, set in the sidecar file if any.
+func getRenderableChunk(format InputFormat, lines []string, codeBlockIndex int, language string, renderOptionsJSON string, fileDir string, baseOptions RenderOptions) (*Chunk, error) {
+	chunk := &Chunk{}
+	chunk.IsRenderable = true
+	chunk.Language = language
+	chunk.WrittenLanguage, _, _ = format.splitOpeningLine(lines[codeBlockIndex])
+	chunk.CodeBlockIndex = codeBlockIndex
+	chunk.Format = format
+	chunk.FileDir = fileDir
+
+	// For MDX, where braces are not allowed in the opening fence, the render
+	// options can be set in a comment on the line above the fence instead
+	renderOptionsJSON = strings.TrimSpace(renderOptionsJSON)
+	optionsCommentIndex := -1
+	if _, ok := format.(MarkdownFormat); ok && renderOptionsJSON == "" && codeBlockIndex > 0 {
+		if v, ok := matchRenderOptionsComment(lines[codeBlockIndex-1]); ok {
+			renderOptionsJSON = v
+			optionsCommentIndex = codeBlockIndex - 1
+		}
+	}
+
+	renderOptions := baseOptions
+	if strings.HasPrefix(renderOptionsJSON, "{") && strings.HasSuffix(renderOptionsJSON, "}") {
+		decoder := json.NewDecoder(strings.NewReader(renderOptionsJSON))
+		if config.Render.Strict {
+			decoder.DisallowUnknownFields()
+		}
+		err := decoder.Decode(&renderOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "unmarshal render options")
+		}
+	}
+	// Code blocks that do not specify a mode use the default mode of their
+	// language if configured, otherwise the global default
+	if renderOptions.Mode == "" {
+		renderOptions.Mode = config.Render.DefaultModes[language]
+	}
+	err := renderOptions.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "validate render options")
+	}
+	if len(renderOptions.GraphvizArgs()) > 0 && language != "dot" {
+		return nil, errors.New("validate render options: rankdir and size are only supported for dot")
+	}
+	if renderOptions.Data != "" && language != "dot" {
+		return nil, errors.New("validate render options: data is only supported for dot")
+	}
+	chunk.RenderOptions = renderOptions
+	// The filename derived from the name is handled as a custom filename,
+	// with its hash in a comment
+	if renderOptions.Name != "" {
+		ext := renderOptions.Format
+		if ext == "" {
+			ext = chunk.DefaultExt()
+		}
+		chunk.RenderOptions.Filename = slugify(renderOptions.Name) + "." + ext
+	}
+	if chunk.HasViewer() {
+		err := chunk.validateViewer()
+		if err != nil {
+			return nil, errors.Wrap(err, "validate render options")
+		}
+	}
+	if language == "structurizr" {
+		err := chunk.validateStructurizr()
+		if err != nil {
+			return nil, errors.Wrap(err, "validate render options")
+		}
+	}
+
+	// These modes wrap the code block in an HTML comment, which the options
+	// comment would close early
+	if optionsCommentIndex >= 0 && (renderOptions.Mode == "code-hidden" || renderOptions.Mode == "external") {
+		return nil, errors.Errorf("mode %s cannot be set in a render options comment", renderOptions.Mode)
+	}
+
+	// Relative paths in remote input files would be resolved against the
+	// current directory, reading local files the input file does not own
+	if fileDir == "" {
+		switch {
+		case renderOptions.Include != "":
+			return nil, errors.New("validate render options: include is not supported in remote input files")
+		case len(renderOptions.IncludeBefore) > 0:
+			return nil, errors.New("validate render options: include_before is not supported in remote input files")
+		case renderOptions.Data != "":
+			return nil, errors.New("validate render options: data is not supported in remote input files")
+		case renderOptions.Mode == "external":
+			return nil, errors.New("validate render options: mode external is not supported in remote input files")
+		}
+	}
+
+	// Add a hash comment if a custom filename is set
+	if chunk.RenderOptions.Filename != "" {
+		chunk.HasHashComment = true
+	}
+
+	// The chunk is parsed as if the options comment was not there, and the
+	// comment is added back in front of the fence afterwards.
+	parseLines, parseIndex := lines, codeBlockIndex
+	if optionsCommentIndex >= 0 {
+		parseLines = append(append([]string{}, lines[:optionsCommentIndex]...), lines[codeBlockIndex:]...)
+		parseIndex = optionsCommentIndex
+	}
+	err = format.ParseChunk(parseLines, parseIndex, chunk)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse render template")
+	}
+	if optionsCommentIndex >= 0 {
+		chunk.insertLineBefore(lines[codeBlockIndex], lines[optionsCommentIndex])
+		chunk.EndLineIndex++
+		chunk.CodeBlockContentIndex++
+	}
+
+	// The included file is rendered and hashed in place of the code block
+	if chunk.RenderOptions.Include != "" {
+		includePath := filepath.Join(fileDir, filepath.FromSlash(chunk.RenderOptions.Include))
+		if !isWithinDir(fileDir, includePath) {
+			return nil, fmt.Errorf("included file %s is outside of the directory of the input file", chunk.RenderOptions.Include)
+		}
+		b, err := os.ReadFile(includePath)
+		if err != nil {
+			return nil, errors.Wrap(err, "read included file")
+		}
+		chunk.CodeBlockContent = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	var snippets []string
+	for _, v := range chunk.RenderOptions.IncludeBefore {
+		snippetPath := filepath.Join(fileDir, filepath.FromSlash(v))
+		if !isWithinDir(fileDir, snippetPath) {
+			return nil, fmt.Errorf("include_before file %s is outside of the directory of the input file", v)
+		}
+		b, err := os.ReadFile(snippetPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "read include_before file")
+		}
+		snippets = append(snippets, strings.TrimSuffix(string(b), "\n"))
+	}
+	chunk.IncludedBefore = strings.Join(snippets, "\n")
+	if chunk.RenderOptions.Data != "" {
+		dataPath := filepath.Join(fileDir, filepath.FromSlash(chunk.RenderOptions.Data))
+		if !isWithinDir(fileDir, dataPath) {
+			return nil, fmt.Errorf("data file %s is outside of the directory of the input file", chunk.RenderOptions.Data)
+		}
+		chunk.IncludedData, err = expandData(dataPath, chunk.RenderOptions.Template)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chunk.nameSourceFile()
+	return chunk, nil
+}
+
+// matchRenderOptionsComment checks if the line is a comment containing render
+// options, e.g. <!-- render: {"mode": "code-collapsed"} -->
+func matchRenderOptionsComment(line string) (renderOptionsJSON string, ok bool) {
+	prefix := "<!-- " + config.Render.Directive + ":"
+	if !strings.HasPrefix(line, prefix) || !strings.HasSuffix(line, "-->") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, prefix), "-->")), true
+}
+
+// insertLineBefore inserts a line into the chunk's lines, before the first
+// occurrence of the target line.
+func (r *Chunk) insertLineBefore(target string, line string) {
+	for i, v := range r.Lines {
+		if v != target {
+			continue
+		}
+		lines := append([]string{}, r.Lines[:i]...)
+		lines = append(lines, line)
+		r.Lines = append(lines, r.Lines[i:]...)
+		if r.ImageRelativeLineIndex >= i {
+			r.ImageRelativeLineIndex++
+		}
+		return
+	}
+}
+
+// isWithinDir checks that filePath does not escape dir once cleaned.
+func isWithinDir(dir string, filePath string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(filePath))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeFiles removes files on a best-effort basis, logging any failures.
+func removeFiles(filePaths []string) {
+	for _, v := range filePaths {
+		err := os.Remove(v)
+		if err != nil {
+			warnf("Failed to remove %s: %s\n", v, err)
+		}
+	}
+}
+
+// CommandError is returned when a renderer command fails. It contains the
+// stderr output of the command.
+type CommandError struct {
+	Err    error
+	Stderr []byte
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func runShellCommand(command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
+	return runShellCommandWithStderr(os.Stderr, command, args, stdin)
+}
+
+// runShellCommandWithStderr runs a command, printing its stderr to
+// stderrOutput as it runs.
+func runShellCommandWithStderr(stderrOutput io.Writer, command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
+	return runShellCommandWithEnv(stderrOutput, nil, command, args, stdin)
+}
+
+// runShellCommandWithEnv runs a command like runShellCommandWithStderr, with
+// additional environment variables in the form KEY=VALUE. They take precedence
+// over the --render-env variables.
+func runShellCommandWithEnv(stderrOutput io.Writer, env []string, command string, args []string, stdin io.Reader) (stdoutOutput []byte, err error) {
+	err = validateCommandAllowed(command)
+	if err != nil {
+		return nil, err
+	}
+	if commandSemaphore != nil {
+		commandSemaphore <- struct{}{}
+		defer func() { <-commandSemaphore }()
+	}
+	cmd := exec.Command(command, args...)
+	if len(config.Render.RenderEnv) > 0 || len(env) > 0 {
+		cmd.Env = append(append(os.Environ(), config.Render.RenderEnv...), env...)
+	}
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = io.MultiWriter(stderrOutput, stderr)
+	cmd.Stdin = stdin
+	stdout := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	err = cmd.Run()
+	if err != nil {
+		return stdout.Bytes(), &CommandError{Err: err, Stderr: stderr.Bytes()}
+	}
+	return stdout.Bytes(), nil
+}
+
+// runFileCommand runs a renderer that reads its input from a file and writes
+// its output to a file, instead of using stdin and stdout. The args function
+// is given the paths of the temporary input and output files.
+func runFileCommand(stderrOutput io.Writer, command string, input string, inputExt string, outputExt string, args func(inputPath, outputPath string) []string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "md-code-renderer-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	inputPath := filepath.Join(dir, "input."+inputExt)
+	outputPath := filepath.Join(dir, "output."+outputExt)
+	err = os.WriteFile(inputPath, []byte(input), 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "write input file")
+	}
+	_, err = runShellCommandWithStderr(stderrOutput, command, args(inputPath, outputPath), nil)
+	if err != nil {
+		return nil, err
+	}
+	content, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "read output file")
+	}
+	return content, nil
+}
+
+// writeDebugFile writes a debugging artifact on a best-effort basis, logging
+// any failures.
+func writeDebugFile(filePath string, content []byte) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err == nil {
+		err = os.WriteFile(filePath, content, 0644)
+	}
+	if err != nil {
+		warnf("Failed to write debug file %s: %s\n", filePath, err)
+	}
+}
+
+// validateCommandAllowed checks the command against the allow-list and
+// deny-list of renderer binaries. An empty allow-list permits all commands.
+func validateCommandAllowed(command string) error {
+	if config.Render.AllowList != "" && !listContains(config.Render.AllowList, command) {
+		return fmt.Errorf("command %s is not in the allow-list", command)
+	}
+	if config.Render.DenyList != "" && listContains(config.Render.DenyList, command) {
+		return fmt.Errorf("command %s is in the deny-list", command)
+	}
+	return nil
+}
+
+// listContains checks if a comma-separated list contains the value.
+func listContains(list string, value string) bool {
+	for _, v := range strings.Split(list, ",") {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func buildMarkdownImage(outputFilename, linkPrefix, alt string) string {
+	return fmt.Sprintf("![%s](%s)", escapeMarkdownAlt(alt), linkPrefix+outputFilename)
+}
+
+// buildDataLink builds a link to rendered data, which cannot be displayed as an
+// image.
+func buildDataLink(outputFilename, linkPrefix string) string {
+	return fmt.Sprintf("[graph data](%s)", linkPrefix+outputFilename)
+}
+
+// buildHTMLImage builds an <img> tag. If hash is not empty, it is included as
+// a data-hash attribute. If dimensions is not nil, they are included as the
+// width and height attributes.
+func buildHTMLImage(outputFilename, linkPrefix, alt, hash string, dimensions *ImageDimensions) string {
+	image := fmt.Sprintf(`<img src="%s" alt="%s"`, linkPrefix+outputFilename, escapeHTMLAlt(alt))
+	if dimensions != nil {
+		image += fmt.Sprintf(` width="%d" height="%d"`, dimensions.Width, dimensions.Height)
+	}
+	if hash != "" {
+		image += fmt.Sprintf(` data-hash="%s"`, hash)
+	}
+	return image + ">"
+}
+
+func buildSourceLink(sourceLink string) string {
+	return fmt.Sprintf("[source](%s)", sourceLink)
+}
+
+// buildHashComment builds a comment containing the hash, the renderer
+// marker, or both.
+func buildHashComment(hash string, renderer string) string {
+	var fields []string
+	if hash != "" {
+		fields = append(fields, "hash:"+hash)
+	}
+	if renderer != "" {
+		fields = append(fields, "renderer:"+renderer)
+	}
+	return fmt.Sprintf("<!-- %s -->", strings.Join(fields, " "))
+}
+
+// writeSourceFile writes the content of an extracted code block to disk,
+// creating any missing parent directories.
+func writeSourceFile(filePath string, content string) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, []byte(content+"\n"), 0644)
+}
+
+func sourceExtFromLanguage(language string) string {
+	switch language {
+	case "plantuml":
+		return "puml"
+	case "mscgen":
+		return "msc"
+	case "math":
+		return "tex"
+	case "railroad":
+		return "ebnf"
+	case "python-plot":
+		return "py"
+	case "chemfig":
+		return "tex"
+	case "structurizr":
+		return "dsl"
+	default:
+		return language
+	}
 }
 
 func extFromFilename(filename string, acceptedExtensions []string, defaultExtension string) string {
@@ -342,6 +2108,8 @@ func getDotFormatFlag(fileExtension string) string {
 		return "-Tpng"
 	case "svg":
 		return "-Tsvg"
+	case "json":
+		return "-Tjson"
 	default:
 		return "-Tsvg"
 	}
@@ -2,11 +2,37 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
 )
 
 // RenderTemplateManager contains methods to handle the templates for different rendering modes.
 type RenderTemplateManager struct{}
 
+// parseMode handles the template for the chunk's mode.
+func (m RenderTemplateManager) parseMode(lines []string, codeBlockIndex int, chunk *Chunk) (err error) {
+	switch chunk.RenderOptions.Mode {
+	case "normal":
+		return m.Normal(lines, codeBlockIndex, chunk)
+	case "code-collapsed":
+		return m.CodeCollapsed(lines, codeBlockIndex, chunk)
+	case "code-collapsed-copy":
+		return m.CodeCollapsedCopy(lines, codeBlockIndex, chunk)
+	case "image-collapsed":
+		return m.ImageCollapsed(lines, codeBlockIndex, chunk)
+	case "code-hidden":
+		return m.CodeHidden(lines, codeBlockIndex, chunk)
+	case "external":
+		return m.External(lines, codeBlockIndex, chunk)
+	default:
+		return errors.New("unsupported mode")
+	}
+}
+
 // Normal handles the template for the "normal" mode. The template looks like:
 //
 //	![]()
@@ -22,31 +48,36 @@ func (m RenderTemplateManager) Normal(lines []string, codeBlockIndex int, chunk
 	chunk.StartLineIndex = codeBlockIndex
 	chunk.EndLineIndex = codeBlockEndIndex
 
-	var isRenderedBefore bool
-	// Check 2 lines above if the image has been rendered before
+	var isRenderedBefore, hasCaption bool
+	// Check 2 lines above if the image has been rendered before, or the
+	// caption below it
 	for i := 1; i <= 2; i++ {
 		idx := codeBlockIndex - i
-		prevLine := lines[idx]
-		hasImage := m.checkForImage(chunk, prevLine, func() {
-			chunk.StartLineIndex = idx
+		imageIndex := m.checkForCaptionedImage(chunk, lines, idx)
+		if imageIndex >= 0 {
+			chunk.StartLineIndex = imageIndex
 			chunk.ImageRelativeLineIndex = 0
-			m.readHashComment(chunk, prevLine)
+			m.readHashComment(chunk, lines[imageIndex])
 			isRenderedBefore = true
-		})
-		if hasImage {
+			hasCaption = imageIndex != idx
 			break
 		}
 	}
 
 	// Render the template into the chunk. Image will be replaced later.
 	if !isRenderedBefore {
+		if config.Render.ReplaceExistingImages {
+			if imageIndex := m.checkForExistingImage(chunk, lines, codeBlockEndIndex); imageIndex >= 0 {
+				chunk.EndLineIndex = imageIndex
+			}
+		}
 		chunk.Lines = []string{"<!-- image here -->", "", fenceStart}
 		chunk.Lines = append(chunk.Lines, chunk.CodeBlockContent...)
 		chunk.Lines = append(chunk.Lines, fenceEnd)
 		chunk.ImageRelativeLineIndex = 0
 		chunk.RenderedHash = ""
 	} else {
-		chunk.Lines = lines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+		chunk.Lines = m.joinCaption(lines[chunk.StartLineIndex:chunk.EndLineIndex+1], hasCaption)
 	}
 	return nil
 }
@@ -62,6 +93,28 @@ func (m RenderTemplateManager) Normal(lines []string, codeBlockIndex int, chunk
 //
 //	</details>
 func (m RenderTemplateManager) CodeCollapsed(lines []string, codeBlockIndex int, chunk *Chunk) (err error) {
+	return m.collapseCode(lines, codeBlockIndex, chunk, "<details><summary>Source</summary>")
+}
+
+// CodeCollapsedCopy handles the template for the "code-collapsed-copy" mode,
+// which marks the collapsed source with a data-copy attribute, for sites that
+// add copy buttons to it. The template looks like:
+//
+//	![]()
+//
+//	<details data-copy><summary>Source</summary>
+//
+//	```dot render
+//	```
+//
+//	</details>
+func (m RenderTemplateManager) CodeCollapsedCopy(lines []string, codeBlockIndex int, chunk *Chunk) (err error) {
+	return m.collapseCode(lines, codeBlockIndex, chunk, "<details data-copy><summary>Source</summary>")
+}
+
+// collapseCode handles the templates that collapse the code block below the
+// image, in a <details> element opened by openingDetailsTag.
+func (m RenderTemplateManager) collapseCode(lines []string, codeBlockIndex int, chunk *Chunk, openingDetailsTag string) (err error) {
 	content, codeBlockEndIndex, fenceStart, fenceEnd, err := m.collectCodeBlock(lines, codeBlockIndex)
 	if err != nil {
 		return err
@@ -73,20 +126,21 @@ func (m RenderTemplateManager) CodeCollapsed(lines []string, codeBlockIndex int,
 	// Check if rendered before
 	closingDetailsTag := "</details>"
 	hasClosingDetailsTag := codeBlockEndIndex+2 < len(lines) && lines[codeBlockEndIndex+2] == closingDetailsTag
-	openingDetailsTag := "<details><summary>Source</summary>"
 	hasOpeningDetailsTag := codeBlockIndex-2 >= 0 && lines[codeBlockIndex-2] == openingDetailsTag
-	var hasImage bool
-	if codeBlockIndex-4 >= 0 {
-		line := lines[codeBlockIndex-4]
-		hasImage = m.checkForImage(chunk, line, func() {
-			chunk.StartLineIndex = codeBlockIndex - 4
-			chunk.ImageRelativeLineIndex = 0
-			m.readHashComment(chunk, line)
-		})
+	imageIndex := m.checkForCaptionedImage(chunk, lines, codeBlockIndex-4)
+	hasImage := imageIndex >= 0
+	hasCaption := hasImage && imageIndex != codeBlockIndex-4
+	if hasImage {
+		chunk.StartLineIndex = imageIndex
+		chunk.ImageRelativeLineIndex = 0
+		m.readHashComment(chunk, lines[imageIndex])
 	}
 
 	// Render the template into the chunk. Image will be replaced later.
 	isRenderedBefore := hasClosingDetailsTag && hasOpeningDetailsTag && hasImage
+	if isRenderedBefore {
+		chunk.EndLineIndex = codeBlockEndIndex + 2
+	}
 	if !isRenderedBefore {
 		chunk.Lines = []string{"<!-- image here -->", "", openingDetailsTag, "", fenceStart}
 		chunk.Lines = append(chunk.Lines, chunk.CodeBlockContent...)
@@ -94,7 +148,7 @@ func (m RenderTemplateManager) CodeCollapsed(lines []string, codeBlockIndex int,
 		chunk.ImageRelativeLineIndex = 0
 		chunk.RenderedHash = ""
 	} else {
-		chunk.Lines = lines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+		chunk.Lines = m.joinCaption(lines[chunk.StartLineIndex:chunk.EndLineIndex+1], hasCaption)
 	}
 	return nil
 }
@@ -168,18 +222,20 @@ func (m RenderTemplateManager) CodeHidden(lines []string, codeBlockIndex int, ch
 	hasOpeningCommentTag := codeBlockIndex-1 > 0 && lines[codeBlockIndex-1] == openingCommentTag
 	closingCommentTag := "-->"
 	hasClosingCommentTag := codeBlockEndIndex+1 < len(lines) && lines[codeBlockEndIndex+1] == closingCommentTag
-	var hasImage bool
-	if codeBlockIndex-3 > 0 {
-		line := lines[codeBlockIndex-3]
-		hasImage = m.checkForImage(chunk, line, func() {
-			chunk.StartLineIndex = codeBlockIndex - 3
-			chunk.ImageRelativeLineIndex = 0
-			m.readHashComment(chunk, line)
-		})
+	imageIndex := m.checkForCaptionedImage(chunk, lines, codeBlockIndex-3)
+	hasImage := imageIndex >= 0
+	hasCaption := hasImage && imageIndex != codeBlockIndex-3
+	if hasImage {
+		chunk.StartLineIndex = imageIndex
+		chunk.ImageRelativeLineIndex = 0
+		m.readHashComment(chunk, lines[imageIndex])
 	}
 
 	// Render the template into the chunk. Image will be replaced later.
 	isRenderedBefore := hasOpeningCommentTag && hasClosingCommentTag && hasImage
+	if isRenderedBefore {
+		chunk.EndLineIndex = codeBlockEndIndex + 1
+	}
 	if !isRenderedBefore {
 		chunk.Lines = []string{"<!-- image here -->", "", openingCommentTag, fenceStart}
 		chunk.Lines = append(chunk.Lines, chunk.CodeBlockContent...)
@@ -187,11 +243,103 @@ func (m RenderTemplateManager) CodeHidden(lines []string, codeBlockIndex int, ch
 		chunk.ImageRelativeLineIndex = 0
 		chunk.RenderedHash = ""
 	} else {
-		chunk.Lines = lines[chunk.StartLineIndex : chunk.EndLineIndex+1]
+		chunk.Lines = m.joinCaption(lines[chunk.StartLineIndex:chunk.EndLineIndex+1], hasCaption)
+	}
+	return nil
+}
+
+// External handles the template for the "external" mode. The code block
+// is extracted into a source file, which becomes the source of truth for
+// subsequent renders. The template looks like:
+//
+//	![]()
+//
+//	[source](diagrams/render-{hash}.dot)
+//
+//	<!--
+//	```dot render{"mode": "external"}
+//	```
+//	-->
+func (m RenderTemplateManager) External(lines []string, codeBlockIndex int, chunk *Chunk) (err error) {
+	content, codeBlockEndIndex, fenceStart, fenceEnd, err := m.collectCodeBlock(lines, codeBlockIndex)
+	if err != nil {
+		return err
+	}
+	chunk.CodeBlockContent = content
+	chunk.StartLineIndex = codeBlockIndex
+	chunk.EndLineIndex = codeBlockEndIndex
+
+	// Check if rendered before
+	openingCommentTag := "<!--"
+	hasOpeningCommentTag := codeBlockIndex-1 >= 0 && lines[codeBlockIndex-1] == openingCommentTag
+	closingCommentTag := "-->"
+	hasClosingCommentTag := codeBlockEndIndex+1 < len(lines) && lines[codeBlockEndIndex+1] == closingCommentTag
+	var sourceLink string
+	if codeBlockIndex-3 >= 0 {
+		matches := sourceLinkRegexp.FindStringSubmatch(lines[codeBlockIndex-3])
+		if len(matches) == 2 {
+			sourceLink = matches[1]
+		}
+	}
+	imageIndex := m.checkForCaptionedImage(chunk, lines, codeBlockIndex-5)
+	hasImage := imageIndex >= 0
+	hasCaption := hasImage && imageIndex != codeBlockIndex-5
+	if hasImage {
+		chunk.StartLineIndex = imageIndex
+		chunk.EndLineIndex = codeBlockEndIndex + 1
+		chunk.ImageRelativeLineIndex = 0
+		m.readHashComment(chunk, lines[imageIndex])
+	}
+
+	// If rendered before, the external source file is the source of truth
+	isRenderedBefore := hasOpeningCommentTag && hasClosingCommentTag && sourceLink != "" && hasImage
+	if isRenderedBefore {
+		chunk.SourceLink = sourceLink
+		chunk.SourceFilePath = filepath.Join(chunk.FileDir, filepath.FromSlash(sourceLink))
+		if !isWithinDir(chunk.FileDir, chunk.SourceFilePath) {
+			return fmt.Errorf("source file %s is outside of the directory of the input file", sourceLink)
+		}
+		b, err := os.ReadFile(chunk.SourceFilePath)
+		if err != nil {
+			return err
+		}
+		chunk.CodeBlockContent = strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		chunk.Lines = m.joinCaption(lines[chunk.StartLineIndex:chunk.EndLineIndex+1], hasCaption)
+		return nil
 	}
+
+	// Render the template into the chunk. Image will be replaced later, and
+	// the source file will be written when the chunk is rendered. The source
+	// file is named once the chunk is resolved, see nameSourceFile.
+	chunk.HasPendingSourceFile = true
+	chunk.Lines = []string{"<!-- image here -->", "", buildSourceLink(""), "", openingCommentTag, fenceStart, fenceEnd, closingCommentTag}
+	chunk.ImageRelativeLineIndex = 0
+	chunk.RenderedHash = ""
 	return nil
 }
 
+// nameSourceFile names the source file the external mode extracts the code
+// block to after the chunk's image, e.g. render-{hash}.dot for
+// render-{hash}.svg, so that normalize-hashes renames them together. It is
+// called once the content of the chunk is resolved, since the hash depends
+// on its included snippets and data.
+func (r *Chunk) nameSourceFile() {
+	if !r.HasPendingSourceFile {
+		return
+	}
+	fileName := r.OutputFilename()
+	name := strings.TrimSuffix(fileName, path.Ext(fileName))
+	oldSourceLink := buildSourceLink(r.SourceLink)
+	r.SourceLink = path.Join(config.Render.SourceDir, name+"."+sourceExtFromLanguage(r.Language))
+	r.SourceFilePath = filepath.Join(r.FileDir, filepath.FromSlash(r.SourceLink))
+	for i, line := range r.Lines {
+		if line == oldSourceLink {
+			r.Lines[i] = buildSourceLink(r.SourceLink)
+			break
+		}
+	}
+}
+
 func (m RenderTemplateManager) collectCodeBlock(lines []string, codeBlockIndex int) (content []string, codeBlockEndIndex int, fenceStart string, fenceEnd string, err error) {
 	for i := codeBlockIndex + 1; i < len(lines); i++ {
 		line := lines[i]
@@ -203,32 +351,133 @@ func (m RenderTemplateManager) collectCodeBlock(lines []string, codeBlockIndex i
 	return nil, 0, "", "", errors.New("code block is unterminated")
 }
 
+// checkForExistingImage checks for a hand-placed image right below a code block
+// rendered for the first time, with --replace-existing-images, and returns the
+// index of its line, or -1 if there is none. Only lines containing nothing but
+// a Markdown image are taken over, and never images that were rendered, or
+// that are above another code block and belong to it.
+func (m RenderTemplateManager) checkForExistingImage(chunk *Chunk, lines []string, codeBlockEndIndex int) int {
+	if _, ok := chunk.Format.(MarkdownFormat); !ok {
+		return -1
+	}
+	for i := codeBlockEndIndex + 1; i <= codeBlockEndIndex+2 && i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" {
+			continue
+		}
+		if markdownImageRegexp.FindString(line) != line || renderedImageRegexp.MatchString(line) {
+			return -1
+		}
+		for j := i + 1; j <= i+2 && j < len(lines); j++ {
+			if _, _, ok := chunk.Format.MatchRenderableBlock(lines[j], nil); ok {
+				return -1
+			}
+		}
+		return i
+	}
+	return -1
+}
+
 func (m RenderTemplateManager) checkForImage(chunk *Chunk, line string, imageExistsFn func()) (imageExists bool) {
-	if chunk.RenderOptions.Filename != "" {
-		matches := markdownImageRegexp.FindStringSubmatch(line)
-		if len(matches) == 2 {
-			imageExistsFn()
+	// Data is linked to rather than displayed as an image. With --output-ext,
+	// dot code blocks previously rendered to data have the link replaced.
+	if chunk.IsDataOutput() || (config.Render.OutputExt != "" && chunk.Language == "dot") {
+		if m.checkForDataLink(chunk, line, imageExistsFn) {
 			return true
 		}
+		if chunk.IsDataOutput() {
+			return false
+		}
+	}
+
+	if imageTemplate != nil {
+		return m.checkForTemplateImage(chunk, line, imageExistsFn)
+	}
+
+	// Images can be either in the markdown or HTML form, depending on
+	// the hash style used when they were rendered.
+	if chunk.RenderOptions.Filename != "" {
+		for _, re := range []*regexp.Regexp{markdownImageRegexp, htmlImageRegexp} {
+			matches := re.FindStringSubmatch(line)
+			if len(matches) == 2 {
+				imageExistsFn()
+				return true
+			}
+		}
 	} else {
-		matches := renderedImageRegexp.FindStringSubmatch(line)
-		if len(matches) == 2 {
-			chunk.RenderedHash = matches[1]
-			imageExistsFn()
-			return true
+		for _, re := range []*regexp.Regexp{renderedImageRegexp, renderedHTMLImageRegexp} {
+			matches := re.FindStringSubmatch(line)
+			if len(matches) == 2 {
+				chunk.RenderedHash = matches[1]
+				imageExistsFn()
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkForDataLink checks for a link to the data rendered from a dot code
+// block.
+func (m RenderTemplateManager) checkForDataLink(chunk *Chunk, line string, imageExistsFn func()) (imageExists bool) {
+	matches := dataLinkRegexp.FindStringSubmatch(line)
+	if len(matches) != 2 {
+		return false
+	}
+	if chunk.RenderOptions.Filename == "" {
+		filenameMatches := renderedFilenameRegexp.FindStringSubmatch(path.Base(matches[1]))
+		if len(filenameMatches) != 2 {
+			return false
 		}
+		chunk.RenderedHash = filenameMatches[1]
+	}
+	imageExistsFn()
+	return true
+}
+
+// checkForTemplateImage checks for an image built from the custom image
+// template.
+func (m RenderTemplateManager) checkForTemplateImage(chunk *Chunk, line string, imageExistsFn func()) (imageExists bool) {
+	filename, hash, ok := imageTemplate.Match(line)
+	if !ok {
+		return false
+	}
+	if chunk.RenderOptions.Filename != "" {
+		if hash != "" {
+			chunk.RenderedHash = hash
+		}
+		imageExistsFn()
+		return true
+	}
+	matches := renderedFilenameRegexp.FindStringSubmatch(filename)
+	if len(matches) == 2 {
+		chunk.RenderedHash = matches[1]
+		imageExistsFn()
+		return true
 	}
 	return false
 }
 
 func (m RenderTemplateManager) readHashComment(chunk *Chunk, line string) (hasHash bool) {
+	rendererMatches := rendererMarkerRegexp.FindAllStringSubmatch(line, -1)
+	if len(rendererMatches) > 0 {
+		chunk.RenderedRenderer = rendererMatches[len(rendererMatches)-1][1]
+	}
 	// Only check for the hash comment if a custom filename is set.
 	// Otherwise the hash is contained in the auto-generated filename
 	// instead.
 	if chunk.RenderOptions.Filename == "" {
 		return
 	}
-	matches := renderedHashRegexp.FindStringSubmatch(line)
+	// If the line has accumulated multiple hash comments, the last one is
+	// the most recent. The rest are stale and will be cleaned up.
+	allMatches := renderedHashRegexp.FindAllStringSubmatch(line, -1)
+	if len(allMatches) > 0 {
+		chunk.RenderedHash = allMatches[len(allMatches)-1][1]
+		chunk.HasStaleHashComments = len(allMatches) > 1
+		return true
+	}
+	matches := renderedHashAttributeRegexp.FindStringSubmatch(line)
 	if len(matches) == 2 {
 		chunk.RenderedHash = matches[1]
 		return true
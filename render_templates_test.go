@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadHashComment(t *testing.T) {
+	tests := []struct {
+		name             string
+		filename         string
+		line             string
+		expectedHasHash  bool
+		expectedHash     string
+		expectedHasStale bool
+		expectedRenderer string
+	}{
+		{
+			name:            "custom filename",
+			filename:        "custom.svg",
+			line:            "![custom.svg](custom.svg) <!-- hash:aaaaaaaa -->",
+			expectedHasHash: true,
+			expectedHash:    "aaaaaaaa",
+		},
+		{
+			name:             "two hash comments",
+			filename:         "custom.svg",
+			line:             "![custom.svg](custom.svg) <!-- hash:aaaaaaaa --> <!-- hash:bbbbbbbb renderer:dot@2.43.0 -->",
+			expectedHasHash:  true,
+			expectedHash:     "bbbbbbbb",
+			expectedHasStale: true,
+			expectedRenderer: "dot@2.43.0",
+		},
+		{
+			name: "generated filename",
+			line: "![render-aaaaaaaa.svg](render-aaaaaaaa.svg) <!-- hash:bbbbbbbb -->",
+		},
+		{
+			name:     "custom filename without a hash comment",
+			filename: "custom.svg",
+			line:     "![custom.svg](custom.svg)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := &Chunk{RenderOptions: RenderOptions{Filename: tt.filename}}
+			hasHash := RenderTemplateManager{}.readHashComment(chunk, tt.line)
+			if hasHash != tt.expectedHasHash {
+				t.Errorf("expected hasHash %v, got %v", tt.expectedHasHash, hasHash)
+			}
+			if chunk.RenderedHash != tt.expectedHash {
+				t.Errorf("expected hash %q, got %q", tt.expectedHash, chunk.RenderedHash)
+			}
+			if chunk.HasStaleHashComments != tt.expectedHasStale {
+				t.Errorf("expected HasStaleHashComments %v, got %v", tt.expectedHasStale, chunk.HasStaleHashComments)
+			}
+			if chunk.RenderedRenderer != tt.expectedRenderer {
+				t.Errorf("expected renderer %q, got %q", tt.expectedRenderer, chunk.RenderedRenderer)
+			}
+		})
+	}
+}
+
+func TestCleanHashComments(t *testing.T) {
+	chunk := &Chunk{
+		Lines:                []string{"![custom.svg](custom.svg) <!-- hash:aaaaaaaa -->  <!-- hash:bbbbbbbb -->"},
+		RenderedHash:         "bbbbbbbb",
+		HasStaleHashComments: true,
+	}
+	chunk.CleanHashComments()
+	expected := "![custom.svg](custom.svg) <!-- hash:bbbbbbbb -->"
+	if chunk.Lines[0] != expected {
+		t.Errorf("expected %q, got %q", expected, chunk.Lines[0])
+	}
+	if chunk.HasStaleHashComments {
+		t.Error("expected the stale hash comments to be cleaned")
+	}
+}
+
+func TestRenderCleansStaleHashComments(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```dot render{\"filename\":\"custom.svg\"}\ndigraph { a }\n```\n")
+	err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := string(b)
+	current := regexp.MustCompile(`<!-- hash:[0-9a-f]+ -->`).FindString(rendered)
+	if current == "" {
+		t.Fatalf("expected the image line to have a hash comment:\n%s", rendered)
+	}
+
+	// A stale hash comment in front of the current one is removed, without
+	// rendering the code block again
+	writeTestFile(t, dir, "doc.md", strings.Replace(rendered, current, "<!-- hash:aaaaaaaa --> "+current, 1))
+	installStubRenderer(t, "dot", `echo "unexpected render" >&2; exit 1`)
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != rendered {
+		t.Errorf("expected:\n%s\ngot:\n%s", rendered, b)
+	}
+}
+
+func TestExternalSourceFileMustBeWithinInputFileDir(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	docsDir := filepath.Join(dir, "docs")
+	err := os.Mkdir(docsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "secret.dot", "digraph { secret }\n")
+	filePath := writeTestFile(t, docsDir, "doc.md", strings.Join([]string{
+		"![render-d41d8cd98f00b204e9800998ecf8427e.svg](render-d41d8cd98f00b204e9800998ecf8427e.svg)",
+		"",
+		"[source](../secret.dot)",
+		"",
+		"<!--",
+		"```dot render{\"mode\":\"external\"}",
+		"```",
+		"-->",
+		"",
+	}, "\n"))
+
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", docsDir, filePath)
+	if err == nil || !strings.Contains(err.Error(), "source file ../secret.dot is outside of the directory of the input file") {
+		t.Errorf("expected the source file to be rejected, got %v", err)
+	}
+}
+
+func TestExternalSourceFileIsNamedAfterTheImage(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	writeTestFile(t, dir, "legend.dot", "legend")
+	filePath := writeTestFile(t, dir, "doc.md", "```dot render{\"mode\":\"external\",\"include_before\":[\"legend.dot\"]}\ndigraph { a }   \n```\n")
+
+	err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--trim-trailing-whitespace", filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, _ := filepath.Glob(filepath.Join(dir, "render-*.svg"))
+	if len(images) != 1 {
+		t.Fatalf("expected one image, found %v", images)
+	}
+	sourceLink := "diagrams/" + strings.TrimSuffix(filepath.Base(images[0]), ".svg") + ".dot"
+	sourceFilePath := filepath.Join(dir, filepath.FromSlash(sourceLink))
+	if _, err := os.Stat(sourceFilePath); err != nil {
+		t.Errorf("expected the source file to be named after the image: %v", err)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), buildSourceLink(sourceLink)) {
+		t.Errorf("expected the document to link to %s:\n%s", sourceLink, b)
+	}
+}
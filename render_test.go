@@ -0,0 +1,378 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installStubRenderer puts an executable script named after the renderer
+// first in PATH, for the duration of the test.
+func installStubRenderer(t *testing.T, name string, script string) {
+	t.Helper()
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	t.Cleanup(func() {
+		os.Setenv("PATH", oldPath)
+	})
+}
+
+// runCommand runs the command line with the given arguments, as main does.
+// The state of previous runs is reset, as if it was a new process.
+func runCommand(t *testing.T, args ...string) error {
+	t.Helper()
+	config = Config{}
+	lockfile = nil
+	incrementalState = nil
+	imageTemplate = nil
+	blockTemplate = nil
+	generatedFiles = make(map[string]bool)
+	brokenLinkCount = 0
+	outputClaims = make(map[string]outputClaim)
+	manifest = []ManifestEntry{}
+	oldLogOutput := logOutput
+	logOutput = io.Discard
+	t.Cleanup(func() {
+		logOutput = oldLogOutput
+	})
+	cmd := NewRootCmd()
+	cmd.SetArgs(args)
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+	return cmd.Execute()
+}
+
+// writeTestFile writes a file in dir, returning its path.
+func writeTestFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	filePath := filepath.Join(dir, name)
+	err := os.WriteFile(filePath, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestRenderNoRewriteLeavesInputUnchanged(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	// The first image has stale hash comments, which are cleaned up when
+	// rewriting, and the second code block has trailing whitespace, which
+	// is trimmed from the code blocks that are rendered
+	input := strings.Join([]string{
+		"# Title",
+		"",
+		"![custom.svg](custom.svg) <!-- hash:aaaaaaaa --> <!-- hash:bbbbbbbb -->",
+		"",
+		"```dot render{\"filename\":\"custom.svg\"}",
+		"digraph { a }",
+		"```",
+		"",
+		"```dot render",
+		"digraph { b }   ",
+		"```",
+		"",
+	}, "\n")
+	for _, newlineEOF := range []string{"preserve", "ensure", "strip"} {
+		t.Run(newlineEOF, func(t *testing.T) {
+			dir := t.TempDir()
+			filePath := writeTestFile(t, dir, "doc.md", input)
+			writeTestFile(t, dir, "custom.svg", "<svg></svg>\n")
+
+			err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--no-rewrite", "--trim-trailing-whitespace", "--newline-eof", newlineEOF, filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := os.ReadFile(filePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != input {
+				t.Errorf("input file was rewritten:\n%s", b)
+			}
+			matches, _ := filepath.Glob(filepath.Join(dir, "render-*.svg"))
+			if len(matches) != 1 {
+				t.Errorf("expected the image of the second code block to be written, found %v", matches)
+			}
+		})
+	}
+}
+
+func TestRenderFailedCommitKeepsExistingFiles(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", strings.Join([]string{
+		"```dot render{\"filename\":\"existing.svg\"}",
+		"digraph { a }",
+		"```",
+		"",
+		"```dot render",
+		"digraph { b }",
+		"```",
+		"",
+		"```dot render{\"filename\":\"blocked.svg\"}",
+		"digraph { c }",
+		"```",
+	}, "\n"))
+	existingFilePath := writeTestFile(t, dir, "existing.svg", "<svg></svg>\n")
+	// A directory in place of the image fails the commit of the last code block
+	err := os.Mkdir(filepath.Join(dir, "blocked.svg"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, filePath)
+	if err == nil {
+		t.Fatal("expected the render to fail")
+	}
+	if _, err := os.Stat(existingFilePath); err != nil {
+		t.Errorf("existing image was removed: %v", err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "render-*.svg"))
+	if len(matches) != 0 {
+		t.Errorf("expected the images created by the failed render to be removed, found %v", matches)
+	}
+}
+
+func TestDataOutputSkipsImageProcessing(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+	config.Render.ValidateSVG = true
+	config.Render.Background = "#ffffff"
+
+	data := &Chunk{Language: "dot", RenderOptions: RenderOptions{Filename: "graph.json"}, CodeBlockContent: []string{"digraph { a }"}}
+	image := &Chunk{Language: "dot", CodeBlockContent: []string{"digraph { a }"}}
+	if format := data.OutputFormat(); format != "json" {
+		t.Errorf("expected the json format, got %s", format)
+	}
+	if data.CacheKey("digraph { a }") == image.CacheKey("digraph { a }") {
+		t.Error("expected the data and the image of the same source to have different cache keys")
+	}
+	content := []byte(`{"name":"%3","objects":[]}`)
+	processed, err := data.processImage(content, "graph.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(processed) != string(content) {
+		t.Errorf("expected the data to be left as is, got %s", processed)
+	}
+}
+
+func TestValidateFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		isValid  bool
+	}{
+		{"diagram.svg", true},
+		{"my diagram.png", true},
+		{"..diagram.svg", true},
+		{"../diagram.svg", false},
+		{"..\\diagram.svg", false},
+		{"images/diagram.svg", false},
+		{"/tmp/diagram.svg", false},
+		{".", false},
+		{"..", false},
+		{"-diagram.svg", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filename, func(t *testing.T) {
+			err := validateFilename(tt.filename)
+			if tt.isValid && err != nil {
+				t.Errorf("expected %q to be valid, got %v", tt.filename, err)
+			}
+			if !tt.isValid && err == nil {
+				t.Errorf("expected %q to be invalid", tt.filename)
+			}
+		})
+	}
+}
+
+func TestIsWithinDir(t *testing.T) {
+	tests := []struct {
+		dir      string
+		filePath string
+		expected bool
+	}{
+		{"docs", "docs/a.txt", true},
+		{"docs", "docs/nested/dir/a.txt", true},
+		{"docs", "docs/nested/../a.txt", true},
+		{"docs", "docs", true},
+		{"docs/", "./docs/a.txt", true},
+		{".", "a.txt", true},
+		{".", "..a.txt", true},
+		{"/srv/docs", "/srv/docs/nested/a.txt", true},
+		{"docs", "docs/../a.txt", false},
+		{"docs", "../a.txt", false},
+		{"docs", "docs/nested/../../../a.txt", false},
+		{"docs", "docs2/a.txt", false},
+		{".", "..", false},
+		{"/srv/docs", "/srv/a.txt", false},
+		{"/srv/docs", "/etc/passwd", false},
+		{"docs", "/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir+"|"+tt.filePath, func(t *testing.T) {
+			actual := isWithinDir(tt.dir, tt.filePath)
+			if actual != tt.expected {
+				t.Errorf("isWithinDir(%q, %q): expected %v, got %v", tt.dir, tt.filePath, tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestIncludedFilesMustBeWithinInputFileDir(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	docsDir := filepath.Join(dir, "docs")
+	err := os.Mkdir(docsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "outside.dot", "digraph { outside }\n")
+	writeTestFile(t, docsDir, "inside.dot", "digraph { inside }\n")
+	writeTestFile(t, dir, "outside.csv", "from,to\na,b\n")
+	writeTestFile(t, docsDir, "inside.csv", "from,to\na,b\n")
+	tests := []struct {
+		name    string
+		options string
+		isValid bool
+	}{
+		{"include", `{"include":"inside.dot"}`, true},
+		{"include outside", `{"include":"../outside.dot"}`, false},
+		{"include_before", `{"include_before":["inside.dot"]}`, true},
+		{"include_before outside", `{"include_before":["inside.dot","../outside.dot"]}`, false},
+		{"data", `{"data":"inside.csv"}`, true},
+		{"data outside", `{"data":"../outside.csv"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := writeTestFile(t, docsDir, "doc.md", "```dot render"+tt.options+"\n```\n")
+			err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", docsDir, filePath)
+			if tt.isValid && err != nil {
+				t.Errorf("expected the file to be rendered, got %v", err)
+			}
+			if !tt.isValid && (err == nil || !strings.Contains(err.Error(), "outside of the directory of the input file")) {
+				t.Errorf("expected the file to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWarnOnErrorSkipsInvalidCodeBlocks(t *testing.T) {
+	installStubRenderer(t, "dot", `cat >/dev/null; echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>'`)
+	dir := t.TempDir()
+	invalidBlocks := strings.Join([]string{
+		"```dot render{\"mode\":\"unknown\"}",
+		"digraph { a }",
+		"```",
+		"",
+		"```dot render{\"include\":\"missing.dot\"}",
+		"```",
+		"",
+	}, "\n")
+	filePath := writeTestFile(t, dir, "doc.md", invalidBlocks+"```dot render\ndigraph { b }\n```\n")
+
+	err := runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, filePath)
+	if err == nil {
+		t.Fatal("expected the render to fail without --warn-on-error")
+	}
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", "", "--output-dir", dir, "--warn-on-error", filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(b), invalidBlocks) {
+		t.Errorf("expected the invalid code blocks to be left unchanged:\n%s", b)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "render-*.svg"))
+	if len(matches) != 1 {
+		t.Errorf("expected the valid code block to be rendered, found %v", matches)
+	}
+}
+
+func TestRelinkImageLine(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+	config = Config{}
+
+	tests := []struct {
+		name     string
+		filename string
+		line     string
+		expected string
+	}{
+		{
+			name:     "markdown",
+			filename: "graph.svg",
+			line:     "![graph.svg](old/graph.svg) <!-- hash:aaaaaaaa -->",
+			expected: "![graph.svg](new/graph.svg) <!-- hash:aaaaaaaa -->",
+		},
+		{
+			name:     "picture",
+			filename: "graph.svg",
+			line:     `<picture><source srcset="old/graph.svg"><img src="old/graph.png"></picture> <!-- hash:aaaaaaaa -->`,
+			expected: `<picture><source srcset="new/graph.svg"><img src="new/graph.png"></picture> <!-- hash:aaaaaaaa -->`,
+		},
+		{
+			name:     "other files",
+			filename: "graph.svg",
+			line:     "![graph.svg](old/graph.svg) ![other.svg](old/other.svg) <!-- hash:aaaaaaaa -->",
+			expected: "![graph.svg](new/graph.svg) ![other.svg](old/other.svg) <!-- hash:aaaaaaaa -->",
+		},
+		{
+			name:     "filename with spaces",
+			filename: "my graph.svg",
+			line:     "![my graph.svg](old/my graph.svg) <!-- hash:aaaaaaaa -->",
+			expected: "![my graph.svg](new/my graph.svg) <!-- hash:aaaaaaaa -->",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := &Chunk{
+				IsRenderable:  true,
+				Language:      "dot",
+				RenderOptions: RenderOptions{Filename: tt.filename},
+				RenderedHash:  "aaaaaaaa",
+				Lines:         []string{tt.line},
+			}
+			chunk.relinkImageLine("new/")
+			if chunk.Lines[0] != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, chunk.Lines[0])
+			}
+		})
+	}
+}
+
+func TestHashContentIncludesBackground(t *testing.T) {
+	oldConfig := config
+	t.Cleanup(func() {
+		config = oldConfig
+	})
+	config = Config{}
+
+	chunk := &Chunk{Language: "dot", CodeBlockContent: []string{"digraph { a }"}}
+	transparent := chunk.HashContent()
+	config.Render.Background = "#1e1e1e"
+	global := chunk.HashContent()
+	if global == transparent {
+		t.Error("expected the global background to change the hash")
+	}
+	block := &Chunk{Language: "dot", CodeBlockContent: []string{"digraph { a }"}, RenderOptions: RenderOptions{Background: "#1e1e1e"}}
+	config.Render.Background = ""
+	if block.HashContent() != global {
+		t.Error("expected the same background to have the same hash, whether set on the code block or globally")
+	}
+}
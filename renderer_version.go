@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// Commands that print the version of the renderer of each language
+var rendererVersionCommands = map[string][]string{
+	"dot":         {"dot", "-V"},
+	"plantuml":    {"plantuml", "-version"},
+	"pikchr":      {"pikchr", "--version"},
+	"mscgen":      {"mscgen", "-l"},
+	"math":        {"tex2svg", "--version"},
+	"excalidraw":  {"excalidraw-brute-export-cli", "--version"},
+	"railroad":    {"rr", "-version"},
+	"erd":         {"erd", "--version"},
+	"python-plot": {"python3", "--version"},
+	"chemfig":     {"latex", "--version"},
+	"structurizr": {"structurizr-cli", "version"},
+}
+
+// Match the first version number in the output of a version command, e.g.
+// "dot - graphviz version 2.43.0 (0)"
+var rendererVersionRegexp = regexp.MustCompile(`\d+(?:\.\d+)+`)
+
+var (
+	rendererVersionsMutex sync.Mutex
+	rendererVersions      = make(map[string]string)
+)
+
+// rendererMarker identifies the renderer of the language and its version, e.g.
+// "plantuml@1.2023.1". Renderers are probed once per run. If the version
+// cannot be determined, it is reported as unknown.
+func rendererMarker(language string) string {
+	rendererVersionsMutex.Lock()
+	defer rendererVersionsMutex.Unlock()
+	version, ok := rendererVersions[language]
+	if !ok {
+		version = probeRendererVersion(language)
+		rendererVersions[language] = version
+	}
+	return language + "@" + version
+}
+
+func probeRendererVersion(language string) string {
+	command, ok := rendererVersionCommands[language]
+	if !ok || validateCommandAllowed(command[0]) != nil {
+		return "unknown"
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	if len(config.Render.RenderEnv) > 0 {
+		cmd.Env = append(os.Environ(), config.Render.RenderEnv...)
+	}
+	// Some renderers print their version to stderr
+	output, err := cmd.CombinedOutput()
+	version := rendererVersionRegexp.FindString(string(output))
+	if version == "" {
+		if err != nil {
+			warnf("Failed to determine the version of %s: %s\n", command[0], err)
+		}
+		return "unknown"
+	}
+	return version
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// resolveRootPath resolves a relative path against the project root set with
+// --root, instead of the current working directory.
+func resolveRootPath(filePath string) string {
+	if config.Render.Root == "" || filePath == "" || filepath.IsAbs(filePath) || isRemoteInput(filePath) {
+		return filePath
+	}
+	return filepath.Join(config.Render.Root, filePath)
+}
+
+// linkPrefixForFile returns the link prefix to use in the file. With --root,
+// a relative link prefix is a path relative to the root, and is made relative
+// to the file's directory so that the links resolve from the file.
+func linkPrefixForFile(filePath string) string {
+	prefix := config.Render.LinkPrefix
+	if config.Render.Root == "" || prefix == "" || path.IsAbs(prefix) || strings.Contains(prefix, "://") || isRemoteInput(filePath) {
+		return prefix
+	}
+	fileDir, err := filepath.Abs(filepath.Dir(filePath))
+	if err != nil {
+		return prefix
+	}
+	target, err := filepath.Abs(filepath.Join(config.Render.Root, filepath.FromSlash(prefix)))
+	if err != nil {
+		return prefix
+	}
+	rel, err := filepath.Rel(fileDir, target)
+	if err != nil {
+		return prefix
+	}
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(prefix, "/") {
+		rel += "/"
+	}
+	return rel
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// Sidecar holds the render options of the code blocks of a file, read from a
+// <file>.render.json sidecar file. The options are keyed by the number of the
+// code block in the file, counting the code blocks marked for rendering from
+// 1, e.g. {"2": {"mode": "code-collapsed"}}.
+type Sidecar map[string]RenderOptions
+
+// loadSidecar reads the sidecar file of the input file, if any. Remote input
+// files have no sidecar file.
+func loadSidecar(filePath string) (Sidecar, error) {
+	if isRemoteInput(filePath) {
+		return nil, nil
+	}
+	b, err := os.ReadFile(filePath + ".render.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read sidecar file")
+	}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if config.Render.Strict {
+		decoder.DisallowUnknownFields()
+	}
+	var sidecar Sidecar
+	err = decoder.Decode(&sidecar)
+	if err != nil {
+		return nil, errors.Wrap(err, "unmarshal sidecar file")
+	}
+	return sidecar, nil
+}
+
+// Options returns the render options of the code block with the number.
+func (s Sidecar) Options(blockNumber int) RenderOptions {
+	return s[strconv.Itoa(blockNumber)]
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// slugify builds the filename of a chunk from its name option, without the
+// extension, e.g. system-architecture for System Architecture. Accents are
+// removed, and runs of other characters than ASCII letters and digits become
+// a single hyphen, so that the filename reads well in URLs.
+func slugify(name string) string {
+	var b strings.Builder
+	isSeparated := false
+	for _, c := range norm.NFD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, c):
+			// Accents are decomposed into marks following their letter
+			continue
+		case c >= 'a' && c <= 'z' || c >= '0' && c <= '9':
+		case c >= 'A' && c <= 'Z':
+			c = unicode.ToLower(c)
+		default:
+			isSeparated = b.Len() > 0
+			continue
+		}
+		if isSeparated {
+			b.WriteByte('-')
+			isSeparated = false
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// DiagramStats aggregates the metrics of a group of code blocks marked for
+// rendering.
+type DiagramStats struct {
+	Diagrams      int
+	TotalLines    int
+	MaxLines      int
+	MaxLocation   string // File and line of the code block with the most lines
+	RenderedBytes int64  // Total size of the rendered images that exist
+}
+
+// Add adds the metrics of a code block.
+func (s *DiagramStats) Add(location string, lines int, renderedBytes int64) {
+	s.Diagrams++
+	s.TotalLines += lines
+	if lines > s.MaxLines || s.MaxLocation == "" {
+		s.MaxLines = lines
+		s.MaxLocation = location
+	}
+	s.RenderedBytes += renderedBytes
+}
+
+// AverageLines returns the average number of lines of the code blocks.
+func (s *DiagramStats) AverageLines() float64 {
+	if s.Diagrams == 0 {
+		return 0
+	}
+	return float64(s.TotalLines) / float64(s.Diagrams)
+}
+
+func NewStatsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Report metrics of the code blocks marked for rendering in markdown files, to spot diagrams that should be split",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: statsCmd,
+	}
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "Languages to report. Comma-separated. If not specified, code blocks of any language are reported.")
+	cmd.Flags().StringVar(&config.Render.InputFormat, "input-format", "auto", "Format of the input files. Supported formats: [auto, markdown, asciidoc].")
+	cmd.Flags().StringVar(&config.Render.Directive, "directive", "render", "Keyword in the opening fence that marks a code block for rendering")
+	cmd.Flags().StringVar(&config.Render.OutputDir, "output-dir", "", "Directory the code blocks are rendered to, to sum the size of the rendered images. If not specified, the current directory is used.")
+	cmd.Flags().IntVar(&config.Stats.Top, "top", 10, "Number of files with the most diagrams to report")
+	return cmd
+}
+
+func statsCmd(cmd *cobra.Command, args []string) error {
+	var languages []string
+	if config.Render.Languages != "" {
+		languages = strings.Split(config.Render.Languages, ",")
+	}
+	total := &DiagramStats{}
+	byLanguage := make(map[string]*DiagramStats)
+	byFile := make(map[string]int)
+	for _, v := range args {
+		err := statsFile(v, languages, total, byLanguage, byFile)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("stats for file %s", v))
+		}
+	}
+
+	var names []string
+	for name := range byLanguage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "LANGUAGE\tDIAGRAMS\tAVG LINES\tMAX LINES\tLARGEST\tRENDERED BYTES")
+	printStats := func(name string, s *DiagramStats) {
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%d\t%s\t%d\n", name, s.Diagrams, s.AverageLines(), s.MaxLines, s.MaxLocation, s.RenderedBytes)
+	}
+	for _, name := range names {
+		printStats(name, byLanguage[name])
+	}
+	printStats("total", total)
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	// Files with the most diagrams first
+	var files []string
+	for name := range byFile {
+		files = append(files, name)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if byFile[files[i]] != byFile[files[j]] {
+			return byFile[files[i]] > byFile[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	if len(files) > config.Stats.Top {
+		files = files[:config.Stats.Top]
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tDIAGRAMS")
+	for _, name := range files {
+		fmt.Fprintf(w, "%s\t%d\n", name, byFile[name])
+	}
+	return w.Flush()
+}
+
+// statsFile adds the metrics of each renderable chunk in the file.
+func statsFile(filePath string, types []string, total *DiagramStats, byLanguage map[string]*DiagramStats, byFile map[string]int) error {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(content, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		location := fmt.Sprintf("%s:%d", filePath, chunk.CodeBlockIndex+1)
+		var renderedBytes int64
+		if fileInfo, err := os.Stat(path.Join(config.Render.OutputDir, chunk.OutputSubdir(), chunk.OutputFilename())); err == nil {
+			renderedBytes = fileInfo.Size()
+		}
+		if byLanguage[chunk.Language] == nil {
+			byLanguage[chunk.Language] = &DiagramStats{}
+		}
+		byLanguage[chunk.Language].Add(location, len(chunk.CodeBlockContent), renderedBytes)
+		total.Add(location, len(chunk.CodeBlockContent), renderedBytes)
+		byFile[filePath]++
+	}
+	return nil
+}
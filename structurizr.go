@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Match the characters of a view key that are not kept in filenames
+var structurizrViewKeyRegexp = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Match: ![alt](path/to/image.svg) or <img src="path/to/image.svg"
+// Capture group on the path, in either of the two.
+var imageLinkPathRegexp = regexp.MustCompile(`!\[[^\]]*\]\(([^()\s]+)\)|<img src="([^"]+)"`)
+
+// StructurizrView is a view of a structurizr workspace, rendered to its own
+// image.
+type StructurizrView struct {
+	Key      string `json:"key"`
+	Content  []byte `json:"content"`
+	FilePath string `json:"-"` // Where the image will be written to when the chunk is committed
+}
+
+// validateStructurizr checks that the images of the chunk's views can be
+// linked to. All views are linked to on the image line, which is only
+// supported for Markdown images without an image template.
+func (r *Chunk) validateStructurizr() error {
+	if _, ok := r.Format.(MarkdownFormat); !ok || imageTemplate != nil {
+		return errors.New("structurizr is only supported for markdown images without an image template")
+	}
+	if r.HasViewer() {
+		return errors.New("viewer is not supported for structurizr")
+	}
+	return nil
+}
+
+// ViewFilename returns the filename the image of a view after the first is
+// rendered to, which is the filename of the first view with the view key
+// inserted before the extension.
+func (r *Chunk) ViewFilename(key string) string {
+	fileName := r.OutputFilename()
+	ext := path.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + "." + key + ext
+}
+
+// ViewFilenames returns the filenames of the images of the chunk's views after
+// the first. The views of a workspace are only known once it is rendered, so
+// for chunks that were not rendered in this run, they are the views linked to
+// on the image line.
+func (r *Chunk) ViewFilenames() []string {
+	var fileNames []string
+	if len(r.Views) > 0 {
+		for _, v := range r.Views {
+			fileNames = append(fileNames, r.ViewFilename(v.Key))
+		}
+		return fileNames
+	}
+	if r.Language != "structurizr" || len(r.Lines) == 0 {
+		return nil
+	}
+	fileName := r.OutputFilename()
+	ext := path.Ext(fileName)
+	prefix := strings.TrimSuffix(fileName, ext) + "."
+	for _, matches := range imageLinkPathRegexp.FindAllStringSubmatch(r.Lines[r.ImageRelativeLineIndex], -1) {
+		linkPath := matches[1]
+		if linkPath == "" {
+			linkPath = matches[2]
+		}
+		base := path.Base(linkPath)
+		if len(base) <= len(prefix)+len(ext) || !strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, ext) {
+			continue
+		}
+		key := strings.TrimSuffix(strings.TrimPrefix(base, prefix), ext)
+		if !structurizrViewKeyRegexp.MatchString(key) {
+			fileNames = append(fileNames, base)
+		}
+	}
+	return fileNames
+}
+
+// renderStructurizr exports the views of a structurizr workspace to PlantUML
+// with the structurizr CLI, and renders each of them with PlantUML. The
+// views are ordered by key, and returned encoded as JSON, so that they can
+// be cached as a single output.
+func renderStructurizr(stderrOutput io.Writer, codeBlockContent string, ext string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "md-code-renderer-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	workspacePath := filepath.Join(dir, "workspace.dsl")
+	err = os.WriteFile(workspacePath, []byte(codeBlockContent), 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "write workspace file")
+	}
+	_, err = runShellCommandWithStderr(stderrOutput, "structurizr-cli", []string{"export", "-workspace", workspacePath, "-format", "plantuml", "-output", dir}, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "export views")
+	}
+
+	// The views are exported as structurizr-<key>.puml, and their legends
+	// as structurizr-<key>-key.puml
+	pumlPaths, err := filepath.Glob(filepath.Join(dir, "structurizr-*.puml"))
+	if err != nil {
+		return nil, errors.Wrap(err, "list exported views")
+	}
+	var viewPaths []string
+	for _, v := range pumlPaths {
+		if !strings.HasSuffix(v, "-key.puml") {
+			viewPaths = append(viewPaths, v)
+		}
+	}
+	if len(viewPaths) == 0 {
+		return nil, errors.New("workspace has no views")
+	}
+	sort.Strings(viewPaths)
+
+	// A single plantuml process renders all views next to their sources
+	_, err = runShellCommandWithStderr(stderrOutput, "plantuml", append([]string{getPlantUMLFormatFlag(ext)}, viewPaths...), nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "render views")
+	}
+	var views []StructurizrView
+	seenKeys := make(map[string]bool)
+	for _, v := range viewPaths {
+		key := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(v), "structurizr-"), ".puml")
+		key = structurizrViewKeyRegexp.ReplaceAllString(key, "-")
+		if seenKeys[key] {
+			return nil, fmt.Errorf("views have the same key %s once made safe for filenames", key)
+		}
+		seenKeys[key] = true
+		content, err := os.ReadFile(strings.TrimSuffix(v, ".puml") + "." + ext)
+		if err != nil {
+			return nil, errors.Wrap(err, "read rendered view")
+		}
+		views = append(views, StructurizrView{Key: key, Content: content})
+	}
+	return json.Marshal(views)
+}
+
+// splitViews decodes the views rendered from the chunk's workspace. The first
+// view is returned as the chunk's image, and the others are processed and
+// kept in the chunk, to be written when it is committed.
+func (r *Chunk) splitViews(content []byte, outputDir string) ([]byte, error) {
+	var views []StructurizrView
+	err := json.Unmarshal(content, &views)
+	if err != nil || len(views) == 0 {
+		return nil, errors.New("invalid structurizr output")
+	}
+	r.Views = nil
+	for _, v := range views[1:] {
+		v.FilePath = path.Join(outputDir, r.OutputSubdir(), r.ViewFilename(v.Key))
+		v.Content, err = r.processImage(v.Content, v.FilePath)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("view %s", v.Key))
+		}
+		r.Views = append(r.Views, v)
+	}
+	return views[0].Content, nil
+}
+
+// buildViewImages builds the images of the chunk's views after the first, to
+// follow the image of the first view on the image line.
+func (r *Chunk) buildViewImages(linkPrefix string) string {
+	var b strings.Builder
+	for _, v := range r.Views {
+		fileName := r.ViewFilename(v.Key)
+		image := buildMarkdownImage(fileName, linkPrefix, r.AltText(fileName))
+		if dimensions := r.contentDimensions(v.Content); dimensions != nil || config.Render.HashStyle == "attribute" {
+			image = buildHTMLImage(fileName, linkPrefix, r.AltText(fileName), "", dimensions)
+		}
+		b.WriteString(" " + image)
+	}
+	return b.String()
+}
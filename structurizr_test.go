@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// installStubStructurizr installs stub structurizr-cli and plantuml commands,
+// exporting and rendering two views, A and B.
+func installStubStructurizr(t *testing.T) {
+	t.Helper()
+	installStubRenderer(t, "structurizr-cli", `echo A > "$7/structurizr-A.puml"; echo B > "$7/structurizr-B.puml"`)
+	installStubRenderer(t, "plantuml", `shift; for f; do echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>' > "${f%.puml}.svg"; done`)
+}
+
+func TestStructurizrViewsAreCheckedLikeTheFirst(t *testing.T) {
+	installStubStructurizr(t)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```structurizr render{\"filename\":\"arch.svg\"}\nworkspace {}\n```\n")
+	viewFilePath := filepath.Join(dir, "arch.B.svg")
+	manifestPath := filepath.Join(dir, "manifest.json")
+	args := []string{"render", "--languages", "structurizr", "--cache-dir", "", "--output-dir", dir, filePath}
+
+	err := runCommand(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(viewFilePath); err != nil {
+		t.Fatalf("expected the second view to be rendered: %v", err)
+	}
+
+	// A missing view is rendered again with --if-missing
+	err = os.Remove(viewFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCommand(t, append(args, "--if-missing")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(viewFilePath); err != nil {
+		t.Errorf("expected the missing view to be rendered with --if-missing: %v", err)
+	}
+
+	// A truncated view is rendered again with --verify-outputs
+	writeTestFile(t, dir, "arch.B.svg", "<svg")
+	err = runCommand(t, append(args, "--verify-outputs")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyOutputFile(viewFilePath, "svg"); err != nil {
+		t.Errorf("expected the truncated view to be rendered with --verify-outputs: %v", err)
+	}
+
+	// Every view is listed in the manifest, even when up to date
+	err = runCommand(t, append(args, "--manifest", manifestPath)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []ManifestEntry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Image != filepath.Join(dir, "arch.svg") || entries[1].Image != viewFilePath {
+		t.Errorf("expected the manifest to list both views, got %+v", entries)
+	}
+}
+
+func TestStructurizrViewsAreClaimed(t *testing.T) {
+	installStubStructurizr(t)
+	dir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```structurizr render{\"filename\":\"arch.svg\"}\nworkspace {}\n```\n")
+	err := runCommand(t, "render", "--languages", "structurizr", "--cache-dir", "", "--output-dir", dir, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second view of the rendered workspace is arch.B.svg
+	otherFilePath := writeTestFile(t, dir, "other.md", "```dot render{\"filename\":\"arch.B.svg\"}\ndigraph { a }\n```\n")
+	err = runCommand(t, "render", "--languages", "structurizr,dot", "--cache-dir", "", "--output-dir", dir, filePath, otherFilePath)
+	if err == nil || !strings.Contains(err.Error(), "output file arch.B.svg is also rendered from") {
+		t.Errorf("expected the output file of the view to be claimed, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Match: href="/abs/path/to/image.png" or xlink:href="file:///abs/path"
+// Capture groups on the attribute up to the opening quote, and the value.
+var svgHrefRegexp = regexp.MustCompile(`(\s(?:xlink:)?href=")([^"]*)"`)
+
+// Match: font-family="/usr/share/fonts/truetype/DejaVuSans.ttf,sans-serif"
+// Capture groups on the attribute up to the opening quote, and the value.
+var svgFontFamilyRegexp = regexp.MustCompile(`(\sfont-family=")([^"]*)"`)
+
+// makeSVGPortable rewrites the absolute paths that renderers embed in SVGs,
+// which break once the SVG is moved to another machine. file:// URLs, and
+// absolute paths of files that exist on disk, are made relative to dir, the
+// directory the SVG is written to. Other hrefs, e.g. site-absolute links like
+// /about, are left as is. Font paths are replaced by the name of the font.
+func makeSVGPortable(content []byte, dir string) []byte {
+	content = svgHrefRegexp.ReplaceAllFunc(content, func(match []byte) []byte {
+		groups := svgHrefRegexp.FindSubmatch(match)
+		value := string(groups[2])
+		isFileURL := strings.HasPrefix(value, "file://")
+		value = strings.TrimPrefix(value, "file://")
+		if !filepath.IsAbs(value) {
+			return match
+		}
+		if !isFileURL {
+			if _, err := os.Stat(value); err != nil {
+				return match
+			}
+		}
+		rel, err := filepath.Rel(dir, value)
+		if err != nil {
+			return match
+		}
+		return []byte(string(groups[1]) + filepath.ToSlash(rel) + `"`)
+	})
+	content = svgFontFamilyRegexp.ReplaceAllFunc(content, func(match []byte) []byte {
+		groups := svgFontFamilyRegexp.FindSubmatch(match)
+		families := strings.Split(string(groups[2]), ",")
+		for i, v := range families {
+			if strings.ContainsAny(v, `/\`) {
+				name := filepath.Base(filepath.FromSlash(strings.TrimSpace(v)))
+				families[i] = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+		}
+		return []byte(string(groups[1]) + strings.Join(families, ",") + `"`)
+	})
+	return content
+}
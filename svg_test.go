@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMakeSVGPortable(t *testing.T) {
+	dir := t.TempDir()
+	outputDir := filepath.Join(dir, "docs", "images")
+	imagePath := writeTestFile(t, dir, "icon.png", "")
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "site-absolute link",
+			content:  `<a xlink:href="/about">`,
+			expected: `<a xlink:href="/about">`,
+		},
+		{
+			name:     "local image",
+			content:  `<image xlink:href="` + filepath.ToSlash(imagePath) + `"/>`,
+			expected: `<image xlink:href="../../icon.png"/>`,
+		},
+		{
+			name:     "file url",
+			content:  `<image href="file://` + filepath.ToSlash(filepath.Join(dir, "missing.png")) + `"/>`,
+			expected: `<image href="../../missing.png"/>`,
+		},
+		{
+			name:     "relative and remote links",
+			content:  `<a href="about.html"><a href="https://example.com/about">`,
+			expected: `<a href="about.html"><a href="https://example.com/about">`,
+		},
+		{
+			name:     "font path",
+			content:  `<text font-family="/usr/share/fonts/truetype/DejaVuSans.ttf,sans-serif">`,
+			expected: `<text font-family="DejaVuSans,sans-serif">`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := string(makeSVGPortable([]byte(tt.content), outputDir))
+			if actual != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import "strings"
+
+// IsSelectedByTags checks if the chunk is rendered on this run with --tags,
+// which selects the code blocks with any of the requested tags. Code blocks
+// without tags are selected by --untagged.
+func (r *Chunk) IsSelectedByTags() bool {
+	if config.Render.Tags == "" {
+		return true
+	}
+	if len(r.RenderOptions.Tags) == 0 {
+		return config.Render.Untagged == "include"
+	}
+	for _, v := range r.RenderOptions.Tags {
+		if listContains(config.Render.Tags, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
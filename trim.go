@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Elements whose content is not drawn where it is defined
+var svgUndrawnElements = map[string]bool{
+	"defs": true, "clipPath": true, "mask": true, "marker": true, "pattern": true, "symbol": true,
+	"linearGradient": true, "radialGradient": true, "filter": true,
+	"title": true, "desc": true, "metadata": true, "style": true, "script": true,
+}
+
+// Match the width and height in the style of the root element of an SVG, as
+// written by plantuml, e.g. style="width:115px;height:127px;"
+var svgStyleDimensionRegexp = regexp.MustCompile(`\b(width|height):\s*[\d.]+px`)
+
+// Match a transform function, e.g. translate(4 112)
+var svgTransformRegexp = regexp.MustCompile(`([a-zA-Z]+)\s*\(([^)]*)\)`)
+
+// Match a command or a number of path data, e.g. M27,-72C27,-64.2
+var svgPathTokenRegexp = regexp.MustCompile(`[MmLlHhVvCcSsQqTtAaZz]|[-+]?(?:\d+\.?\d*|\.\d+)(?:[eE][-+]?\d+)?`)
+
+// The number of parameters of each path command
+var svgPathCommandParams = map[byte]int{'M': 2, 'L': 2, 'H': 1, 'V': 1, 'C': 6, 'S': 4, 'Q': 4, 'T': 2, 'A': 7}
+
+var imageMagickWarning sync.Once
+
+// trimContent trims the whitespace around the chunk's rendered image, with
+// --trim.
+func (r *Chunk) trimContent(content []byte) ([]byte, error) {
+	if r.IsDataOutput() {
+		return content, nil
+	}
+	if r.OutputFormat() == "png" {
+		return trimPNG(r.stderrOutput(), content)
+	}
+	return trimSVG(content), nil
+}
+
+// trimPNG trims the uniform border of a PNG with ImageMagick. If it is not
+// installed, the PNG is left as is.
+func trimPNG(stderrOutput io.Writer, content []byte) ([]byte, error) {
+	command := ""
+	for _, v := range []string{"magick", "convert"} {
+		if _, err := exec.LookPath(v); err == nil {
+			command = v
+			break
+		}
+	}
+	if command == "" {
+		imageMagickWarning.Do(func() {
+			warnf("ImageMagick is not installed, PNGs are not trimmed\n")
+		})
+		return content, nil
+	}
+	output, err := runShellCommandWithStderr(stderrOutput, command, []string{"png:-", "-trim", "+repage", "png:-"}, bytes.NewReader(content))
+	if err != nil {
+		return nil, errors.Wrap(err, "trim png")
+	}
+	return output, nil
+}
+
+// trimSVG tightens the viewBox of an SVG to the bounds of its drawn content,
+// scaling its width and height accordingly. The bounds of text are estimated
+// from the font size. Shapes covering the whole image, such as the background
+// graphviz draws, are not content. SVGs whose content cannot be measured,
+// e.g. because they reference other elements with <use>, are left as is.
+func trimSVG(content []byte) []byte {
+	loc := svgRootTagRegexp.FindIndex(content)
+	if loc == nil {
+		return content
+	}
+	tag := string(content[loc[0]:loc[1]])
+	attributes := make(map[string]string)
+	for _, matches := range svgDimensionAttributeRegexp.FindAllStringSubmatch(tag, -1) {
+		attributes[matches[1]] = matches[2]
+	}
+	width, widthUnit, widthOK := parseSVGUserLength(attributes["width"])
+	height, heightUnit, heightOK := parseSVGUserLength(attributes["height"])
+	viewBox, ok := parseSVGViewBox(attributes["viewBox"])
+	if !ok {
+		if !widthOK || !heightOK {
+			return content
+		}
+		viewBox = svgBounds{0, 0, width, height, true}
+	}
+
+	bounds, ok := svgContentBounds(content, viewBox)
+	if !ok || !bounds.ok {
+		return content
+	}
+	bounds = svgBounds{
+		math.Max(bounds.minX, viewBox.minX), math.Max(bounds.minY, viewBox.minY),
+		math.Min(bounds.maxX, viewBox.maxX), math.Min(bounds.maxY, viewBox.maxY), true,
+	}
+	if bounds.maxX <= bounds.minX || bounds.maxY <= bounds.minY {
+		return content
+	}
+	// Nothing to trim
+	if bounds.minX-viewBox.minX < 0.5 && bounds.minY-viewBox.minY < 0.5 && viewBox.maxX-bounds.maxX < 0.5 && viewBox.maxY-bounds.maxY < 0.5 {
+		return content
+	}
+
+	tag = svgDimensionAttributeRegexp.ReplaceAllString(tag, "")
+	newAttributes := fmt.Sprintf(` viewBox="%s %s %s %s"`, formatSVGNumber(bounds.minX), formatSVGNumber(bounds.minY), formatSVGNumber(bounds.maxX-bounds.minX), formatSVGNumber(bounds.maxY-bounds.minY))
+	if widthOK && heightOK {
+		newWidth := width * (bounds.maxX - bounds.minX) / (viewBox.maxX - viewBox.minX)
+		newHeight := height * (bounds.maxY - bounds.minY) / (viewBox.maxY - viewBox.minY)
+		newAttributes = fmt.Sprintf(` width="%s%s" height="%s%s"`, formatSVGNumber(newWidth), widthUnit, formatSVGNumber(newHeight), heightUnit) + newAttributes
+		tag = svgStyleDimensionRegexp.ReplaceAllStringFunc(tag, func(match string) string {
+			if strings.HasPrefix(match, "width") {
+				return "width:" + formatSVGNumber(newWidth) + "px"
+			}
+			return "height:" + formatSVGNumber(newHeight) + "px"
+		})
+	}
+	tag = "<svg" + newAttributes + strings.TrimPrefix(tag, "<svg")
+	return append(append(append([]byte{}, content[:loc[0]]...), tag...), content[loc[1]:]...)
+}
+
+// svgBounds is a bounding box in user units.
+type svgBounds struct {
+	minX, minY, maxX, maxY float64
+	ok                     bool // Whether any point was added
+}
+
+func (b *svgBounds) add(x, y float64) {
+	if !b.ok {
+		*b = svgBounds{x, y, x, y, true}
+		return
+	}
+	b.minX = math.Min(b.minX, x)
+	b.minY = math.Min(b.minY, y)
+	b.maxX = math.Max(b.maxX, x)
+	b.maxY = math.Max(b.maxY, y)
+}
+
+// covers checks if the bounds contain the other bounds entirely.
+func (b svgBounds) covers(o svgBounds) bool {
+	return b.minX <= o.minX+0.5 && b.minY <= o.minY+0.5 && b.maxX >= o.maxX-0.5 && b.maxY >= o.maxY-0.5
+}
+
+// svgTransform is an affine transform, mapping x, y to
+// a*x + c*y + e, b*x + d*y + f.
+type svgTransform [6]float64
+
+var svgIdentityTransform = svgTransform{1, 0, 0, 1, 0, 0}
+
+// then returns the transform applying o, then t.
+func (t svgTransform) then(o svgTransform) svgTransform {
+	return svgTransform{
+		t[0]*o[0] + t[2]*o[1],
+		t[1]*o[0] + t[3]*o[1],
+		t[0]*o[2] + t[2]*o[3],
+		t[1]*o[2] + t[3]*o[3],
+		t[0]*o[4] + t[2]*o[5] + t[4],
+		t[1]*o[4] + t[3]*o[5] + t[5],
+	}
+}
+
+func (t svgTransform) apply(x, y float64) (float64, float64) {
+	return t[0]*x + t[2]*y + t[4], t[1]*x + t[3]*y + t[5]
+}
+
+// parseSVGTransform parses the value of a transform attribute.
+func parseSVGTransform(value string) (svgTransform, bool) {
+	t := svgIdentityTransform
+	if strings.Trim(svgTransformRegexp.ReplaceAllString(value, ""), " \t\r\n,") != "" {
+		return t, false
+	}
+	for _, matches := range svgTransformRegexp.FindAllStringSubmatch(value, -1) {
+		args, ok := parseSVGNumbers(matches[2])
+		if !ok {
+			return t, false
+		}
+		var o svgTransform
+		switch {
+		case matches[1] == "matrix" && len(args) == 6:
+			o = svgTransform{args[0], args[1], args[2], args[3], args[4], args[5]}
+		case matches[1] == "translate" && len(args) == 1:
+			o = svgTransform{1, 0, 0, 1, args[0], 0}
+		case matches[1] == "translate" && len(args) == 2:
+			o = svgTransform{1, 0, 0, 1, args[0], args[1]}
+		case matches[1] == "scale" && len(args) == 1:
+			o = svgTransform{args[0], 0, 0, args[0], 0, 0}
+		case matches[1] == "scale" && len(args) == 2:
+			o = svgTransform{args[0], 0, 0, args[1], 0, 0}
+		case matches[1] == "rotate" && (len(args) == 1 || len(args) == 3):
+			angle := args[0] * math.Pi / 180
+			o = svgTransform{math.Cos(angle), math.Sin(angle), -math.Sin(angle), math.Cos(angle), 0, 0}
+			if len(args) == 3 {
+				o = svgTransform{1, 0, 0, 1, args[1], args[2]}.then(o).then(svgTransform{1, 0, 0, 1, -args[1], -args[2]})
+			}
+		case matches[1] == "skewX" && len(args) == 1:
+			o = svgTransform{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case matches[1] == "skewY" && len(args) == 1:
+			o = svgTransform{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return t, false
+		}
+		t = t.then(o)
+	}
+	return t, true
+}
+
+// svgContentBounds returns the bounds of the drawn content of an SVG, in the
+// user units of its root element.
+func svgContentBounds(content []byte, viewBox svgBounds) (svgBounds, bool) {
+	var bounds svgBounds
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	transforms := []svgTransform{svgIdentityTransform}
+	undrawnDepth := 0
+	var text *xml.StartElement
+	var textContent strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return bounds, false
+		}
+		switch v := token.(type) {
+		case xml.StartElement:
+			if undrawnDepth > 0 || svgUndrawnElements[v.Name.Local] {
+				undrawnDepth++
+				continue
+			}
+			attributes := make(map[string]string)
+			for _, attr := range v.Attr {
+				attributes[attr.Name.Local] = attr.Value
+			}
+			t := transforms[len(transforms)-1]
+			if value, ok := attributes["transform"]; ok {
+				o, ok := parseSVGTransform(value)
+				if !ok {
+					return bounds, false
+				}
+				t = t.then(o)
+			}
+			// The root element establishes the user units
+			if len(transforms) == 1 && v.Name.Local == "svg" {
+				transforms = append(transforms, t)
+				continue
+			}
+			transforms = append(transforms, t)
+
+			switch v.Name.Local {
+			case "g", "a", "switch":
+			case "text":
+				element := v.Copy()
+				text = &element
+				textContent.Reset()
+			case "tspan", "textPath":
+				// Lines positioned on their own are not estimated
+				for _, name := range []string{"x", "y", "dx", "dy"} {
+					if _, ok := attributes[name]; ok {
+						return bounds, false
+					}
+				}
+			default:
+				local, ok := svgElementBounds(v.Name.Local, attributes)
+				if !ok {
+					return bounds, false
+				}
+				if local.ok {
+					addSVGElementBounds(&bounds, local, t, attributes, viewBox)
+				}
+			}
+		case xml.CharData:
+			if text != nil && undrawnDepth == 0 {
+				textContent.Write(v)
+			}
+		case xml.EndElement:
+			if undrawnDepth > 0 {
+				undrawnDepth--
+				continue
+			}
+			t := transforms[len(transforms)-1]
+			if len(transforms) > 1 {
+				transforms = transforms[:len(transforms)-1]
+			}
+			if text != nil && v.Name.Local == "text" {
+				local, ok := svgTextBounds(*text, strings.TrimSpace(textContent.String()))
+				if !ok {
+					return bounds, false
+				}
+				if local.ok {
+					addSVGElementBounds(&bounds, local, t, nil, viewBox)
+				}
+				text = nil
+			}
+		}
+	}
+	return bounds, true
+}
+
+// addSVGElementBounds adds the bounds of an element, padded by half of its
+// stroke width, to the bounds of the content. Filled shapes covering the
+// whole image are backgrounds, which are not content.
+func addSVGElementBounds(bounds *svgBounds, local svgBounds, t svgTransform, attributes map[string]string, viewBox svgBounds) {
+	pad := 0.0
+	if attributes != nil && attributes["stroke"] != "none" {
+		pad = 0.5
+		if width, _, ok := parseSVGUserLength(attributes["stroke-width"]); ok {
+			pad = width / 2
+		}
+	}
+	var transformed svgBounds
+	for _, x := range []float64{local.minX - pad, local.maxX + pad} {
+		for _, y := range []float64{local.minY - pad, local.maxY + pad} {
+			transformed.add(t.apply(x, y))
+		}
+	}
+	if attributes != nil && transformed.covers(viewBox) {
+		return
+	}
+	bounds.add(transformed.minX, transformed.minY)
+	bounds.add(transformed.maxX, transformed.maxY)
+}
+
+// svgElementBounds returns the bounds of a shape in its own user units. It
+// fails for elements that cannot be measured.
+func svgElementBounds(name string, attributes map[string]string) (svgBounds, bool) {
+	var bounds svgBounds
+	lengths := func(names ...string) ([]float64, bool) {
+		var values []float64
+		for _, v := range names {
+			value, ok := attributes[v]
+			if !ok {
+				value = "0"
+			}
+			length, unit, ok := parseSVGUserLength(value)
+			if !ok || unit == "pt" {
+				return nil, false
+			}
+			values = append(values, length)
+		}
+		return values, true
+	}
+	switch name {
+	case "rect", "image", "foreignObject":
+		v, ok := lengths("x", "y", "width", "height")
+		if !ok {
+			return bounds, false
+		}
+		bounds.add(v[0], v[1])
+		bounds.add(v[0]+v[2], v[1]+v[3])
+	case "circle":
+		v, ok := lengths("cx", "cy", "r")
+		if !ok {
+			return bounds, false
+		}
+		bounds.add(v[0]-v[2], v[1]-v[2])
+		bounds.add(v[0]+v[2], v[1]+v[2])
+	case "ellipse":
+		v, ok := lengths("cx", "cy", "rx", "ry")
+		if !ok {
+			return bounds, false
+		}
+		bounds.add(v[0]-v[2], v[1]-v[3])
+		bounds.add(v[0]+v[2], v[1]+v[3])
+	case "line":
+		v, ok := lengths("x1", "y1", "x2", "y2")
+		if !ok {
+			return bounds, false
+		}
+		bounds.add(v[0], v[1])
+		bounds.add(v[2], v[3])
+	case "polygon", "polyline":
+		v, ok := parseSVGNumbers(attributes["points"])
+		if !ok || len(v)%2 != 0 {
+			return bounds, false
+		}
+		for i := 0; i < len(v); i += 2 {
+			bounds.add(v[i], v[i+1])
+		}
+	case "path":
+		return svgPathBounds(attributes["d"])
+	default:
+		return bounds, false
+	}
+	return bounds, true
+}
+
+// svgPathBounds returns the bounds of path data. Curves are bounded by their
+// control points, and arcs by a box around their endpoints large enough to
+// contain them.
+func svgPathBounds(d string) (svgBounds, bool) {
+	var bounds svgBounds
+	if strings.Trim(svgPathTokenRegexp.ReplaceAllString(d, ""), " \t\r\n,") != "" {
+		return bounds, false
+	}
+	tokens := svgPathTokenRegexp.FindAllString(d, -1)
+	var x, y, startX, startY float64
+	var command byte
+	for i := 0; i < len(tokens); {
+		if c := tokens[i][0]; strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) >= 0 {
+			command = c
+			i++
+			if command == 'Z' || command == 'z' {
+				x, y = startX, startY
+			}
+			continue
+		}
+		upper := command &^ 0x20
+		n, ok := svgPathCommandParams[upper]
+		if !ok || i+n > len(tokens) {
+			return bounds, false
+		}
+		args := make([]float64, n)
+		for j := range args {
+			v, err := strconv.ParseFloat(tokens[i+j], 64)
+			if err != nil {
+				return bounds, false
+			}
+			args[j] = v
+		}
+		i += n
+		relative := command != upper
+		point := func(px, py float64) (float64, float64) {
+			if relative {
+				return x + px, y + py
+			}
+			return px, py
+		}
+		switch upper {
+		case 'M', 'L', 'T':
+			x, y = point(args[0], args[1])
+			bounds.add(x, y)
+			if upper == 'M' {
+				startX, startY = x, y
+				// Further coordinates are lines
+				command = 'L' | (command & 0x20)
+			}
+		case 'H':
+			if relative {
+				x += args[0]
+			} else {
+				x = args[0]
+			}
+			bounds.add(x, y)
+		case 'V':
+			if relative {
+				y += args[0]
+			} else {
+				y = args[0]
+			}
+			bounds.add(x, y)
+		case 'C', 'S', 'Q':
+			var px, py float64
+			for j := 0; j < n; j += 2 {
+				px, py = point(args[j], args[j+1])
+				bounds.add(px, py)
+			}
+			x, y = px, py
+		case 'A':
+			// Flags written without separators, e.g. a1 1 0 01 2 2, are not
+			// tokenized
+			if (args[3] != 0 && args[3] != 1) || (args[4] != 0 && args[4] != 1) {
+				return bounds, false
+			}
+			endX, endY := point(args[5], args[6])
+			r := math.Max(math.Max(math.Abs(args[0]), math.Abs(args[1])), math.Hypot(endX-x, endY-y)/2)
+			for _, p := range [][2]float64{{x, y}, {endX, endY}} {
+				bounds.add(p[0]-2*r, p[1]-2*r)
+				bounds.add(p[0]+2*r, p[1]+2*r)
+			}
+			x, y = endX, endY
+		}
+	}
+	return bounds, true
+}
+
+// svgTextBounds estimates the bounds of a text element from its position, font
+// size and number of characters, generously enough to contain common fonts.
+// Wide characters, e.g. CJK, are counted as one em.
+func svgTextBounds(element xml.StartElement, content string) (svgBounds, bool) {
+	var bounds svgBounds
+	if content == "" {
+		return bounds, true
+	}
+	attributes := make(map[string]string)
+	for _, attr := range element.Attr {
+		attributes[attr.Name.Local] = attr.Value
+	}
+	position := func(name string) (float64, bool) {
+		values, ok := parseSVGNumbers(attributes[name])
+		if !ok {
+			return 0, false
+		}
+		if len(values) == 0 {
+			return 0, true
+		}
+		return values[0], true
+	}
+	x, okX := position("x")
+	y, okY := position("y")
+	if !okX || !okY {
+		return bounds, false
+	}
+	if strings.Contains(attributes["style"], "font") {
+		return bounds, false
+	}
+	fontSize := 16.0
+	if value, ok := attributes["font-size"]; ok {
+		size, unit, ok := parseSVGUserLength(value)
+		if !ok || unit == "pt" {
+			return bounds, false
+		}
+		fontSize = size
+	}
+	width := 0.0
+	for _, r := range content {
+		if r >= 0x2e80 {
+			width += fontSize
+		} else {
+			width += 0.65 * fontSize
+		}
+	}
+	switch attributes["text-anchor"] {
+	case "middle":
+		x -= width / 2
+	case "end":
+		x -= width
+	}
+	bounds.add(x, y-fontSize)
+	bounds.add(x+width, y+0.3*fontSize)
+	return bounds, true
+}
+
+// parseSVGViewBox parses the value of a viewBox attribute.
+func parseSVGViewBox(value string) (svgBounds, bool) {
+	v, ok := parseSVGNumbers(value)
+	if !ok || len(v) != 4 || v[2] <= 0 || v[3] <= 0 {
+		return svgBounds{}, false
+	}
+	return svgBounds{v[0], v[1], v[0] + v[2], v[1] + v[3], true}, true
+}
+
+// parseSVGUserLength parses a length in user units, or in px or pt, returning
+// the unit.
+func parseSVGUserLength(value string) (float64, string, bool) {
+	unit := ""
+	for _, v := range []string{"px", "pt"} {
+		if strings.HasSuffix(value, v) {
+			unit = v
+			value = strings.TrimSuffix(value, v)
+		}
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return v, unit, true
+}
+
+// parseSVGNumbers parses a list of numbers separated by whitespace or commas.
+func parseSVGNumbers(value string) ([]float64, bool) {
+	var values []float64
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}) {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, false
+		}
+		values = append(values, v)
+	}
+	return values, true
+}
+
+func formatSVGNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
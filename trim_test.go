@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTrimSVG(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name: "graphviz",
+			content: `<svg width="100pt" height="160pt" viewBox="0.00 0.00 100.00 160.00" xmlns="http://www.w3.org/2000/svg">
+<g id="graph0" class="graph" transform="scale(1 1) rotate(0) translate(4 156)">
+<title>G</title>
+<polygon fill="white" stroke="none" points="-4,4 -4,-156 96,-156 96,4 -4,4"/>
+<g id="node1" class="node">
+<title>a</title>
+<ellipse fill="none" stroke="black" cx="27" cy="-134" rx="27" ry="18"/>
+<text text-anchor="middle" x="27" y="-130.3" font-family="Times,serif" font-size="14.00">a</text>
+</g>
+</g>
+</svg>`,
+			expected: `<svg width="55pt" height="37pt" viewBox="3.5 3.5 55 37" xmlns="http://www.w3.org/2000/svg">`,
+		},
+		{
+			name: "plantuml",
+			content: `<svg xmlns="http://www.w3.org/2000/svg" contentStyleType="text/css" height="120px" preserveAspectRatio="none" style="width:200px;height:120px;background:#FFFFFF;" version="1.1" viewBox="0 0 200 120" width="200px" zoomAndPan="magnify"><defs/><g>` +
+				`<rect fill="#F1F1F1" height="30" style="stroke:#181818;stroke-width:0.5;" width="60" x="10" y="10"/>` +
+				`<text fill="#000000" font-family="sans-serif" font-size="14" lengthAdjust="spacing" textLength="40" x="20" y="30">Alice</text>` +
+				`</g></svg>`,
+			expected: `<svg width="61px" height="31px" viewBox="9.5 9.5 61 31" xmlns="http://www.w3.org/2000/svg" contentStyleType="text/css" preserveAspectRatio="none" style="width:61px;height:31px;background:#FFFFFF;" version="1.1" zoomAndPan="magnify">`,
+		},
+		{
+			name: "pikchr with relative path commands, an arc and text-anchor end",
+			content: `<svg xmlns='http://www.w3.org/2000/svg' class="pikchr" viewBox="0 0 200 100">
+<path d="M2,50L70,50" style="fill:none;stroke-width:2.16;stroke:rgb(0,0,0);" />
+<path d="m100 20 l20 0 a10 10 0 0 1 0 20 h-20 z" style="fill:none;stroke-width:2.16;stroke:rgb(0,0,0);" />
+<text x="150" y="50" text-anchor="end" fill="rgb(0,0,0)" dominant-baseline="central">end</text>
+</svg>`,
+			expected: `<svg viewBox="1.5 0 148.5 60.5" xmlns='http://www.w3.org/2000/svg' class="pikchr">`,
+		},
+		{
+			name: "nested and rotated transforms",
+			content: `<svg viewBox="0 0 100 100">` +
+				`<g transform="translate(10 20)"><g transform="scale(2)"><rect x="5" y="5" width="10" height="10" stroke="none"/></g></g>` +
+				`<g transform="rotate(90 50 50)"><rect width="10" height="10" stroke="none"/></g>` +
+				`</svg>`,
+			expected: `<svg viewBox="20 0 80 50">`,
+		},
+		{
+			name:     "wide characters",
+			content:  `<svg viewBox="0 0 200 100"><text x="10" y="50" font-size="10">漢字</text></svg>`,
+			expected: `<svg viewBox="10 40 20 13">`,
+		},
+		{
+			name:     "nothing to trim",
+			content:  `<svg viewBox="0 0 20 20"><circle cx="2" cy="2" r="1.5"/><circle cx="18" cy="18" r="1.5"/></svg>`,
+			expected: `<svg viewBox="0 0 20 20">`,
+		},
+		{
+			name:     "referenced elements",
+			content:  `<svg viewBox="0 0 100 100"><defs><rect id="a" width="10" height="10"/></defs><use href="#a" x="10"/></svg>`,
+			expected: `<svg viewBox="0 0 100 100">`,
+		},
+		{
+			name:     "positioned tspan",
+			content:  `<svg viewBox="0 0 100 100"><text x="10" y="10">a<tspan x="10" dy="1.2em">b</tspan></text></svg>`,
+			expected: `<svg viewBox="0 0 100 100">`,
+		},
+		{
+			name:     "unknown transform",
+			content:  `<svg viewBox="0 0 100 100"><g transform="perspective(2)"><rect width="10" height="10"/></g></svg>`,
+			expected: `<svg viewBox="0 0 100 100">`,
+		},
+		{
+			name:     "unknown path command",
+			content:  `<svg viewBox="0 0 100 100"><path d="M10 10 X20 20"/></svg>`,
+			expected: `<svg viewBox="0 0 100 100">`,
+		},
+		{
+			name:     "arc flags without separators",
+			content:  `<svg viewBox="0 0 100 100"><path d="M10 10a5 5 0 0110 0 5 5 0 0110 0"/></svg>`,
+			expected: `<svg viewBox="0 0 100 100">`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := string(trimSVG([]byte(tt.content)))
+			loc := svgRootTagRegexp.FindStringIndex(actual)
+			if loc == nil {
+				t.Fatalf("expected an svg root element, got %s", actual)
+			}
+			if tag := actual[loc[0]:loc[1]]; tag != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, tag)
+			}
+			if actual[loc[1]:] != tt.content[svgRootTagRegexp.FindStringIndex(tt.content)[1]:] {
+				t.Errorf("expected the content after the root element to be unchanged, got %s", actual)
+			}
+		})
+	}
+}
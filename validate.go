@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check that the render directives in markdown files are valid, without rendering",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: validateCmd,
+	}
+	cmd.Flags().StringVar(&config.Render.InputFormat, "input-format", "auto", "Format of the input files. Supported formats: [auto, markdown, asciidoc].")
+	cmd.Flags().StringVar(&config.Render.Directive, "directive", "render", "Keyword in the opening fence that marks a code block for rendering")
+	cmd.Flags().StringVar(&config.Render.Languages, "languages", "", "Languages to validate. Comma-separated. If not specified, code blocks of any language are validated.")
+	cmd.Flags().BoolVar(&config.Render.Strict, "strict", false, "Fail on unknown keys in render options, instead of ignoring them")
+	return cmd
+}
+
+func validateCmd(cmd *cobra.Command, args []string) error {
+	var languages []string
+	if config.Render.Languages != "" {
+		languages = strings.Split(config.Render.Languages, ",")
+	}
+	var errorCount int
+	for _, v := range args {
+		errs, err := validateFile(v, languages)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("validate file %s", v))
+		}
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		errorCount += len(errs)
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("found %d invalid render directives", errorCount)
+	}
+	return nil
+}
+
+// validateFile checks every render directive of the given languages in the
+// file, returning an error for each invalid directive found. If no languages
+// are given, directives of any language are checked.
+func validateFile(filePath string, types []string) (errs []error, err error) {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(content, "\n")
+
+	languageLookup := make(map[string]bool)
+	for _, v := range supportedLanguages {
+		languageLookup[v] = true
+	}
+	var typeLookup map[string]bool
+	if types != nil {
+		typeLookup = make(map[string]bool)
+		for _, v := range types {
+			typeLookup[v] = true
+		}
+	}
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return nil, err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var blockNumber int
+	for idx := 0; idx < len(lines); idx++ {
+		// Match any language, so that unsupported languages can be reported
+		language, renderOptionsJSON, ok := format.MatchRenderableBlock(lines[idx], nil)
+		if !ok {
+			continue
+		}
+		blockNumber++
+		if typeLookup != nil && !typeLookup[language] {
+			continue
+		}
+		if !languageLookup[language] {
+			errs = append(errs, fmt.Errorf("%s:%d: unsupported language: %s", filePath, idx+1, language))
+			continue
+		}
+		chunk, err := getRenderableChunk(format, lines, idx, language, renderOptionsJSON, inputFileDir(filePath), sidecar.Options(blockNumber))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", filePath, idx+1, err))
+			continue
+		}
+		// Skip past the code block
+		if chunk.EndLineIndex > idx {
+			idx = chunk.EndLineIndex
+		}
+	}
+	return errs, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateStrict(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, dir, "input.md", "```dot render{\"filname\":\"graph.svg\"}\ndigraph { a }\n```\n")
+	err := runCommand(t, "validate", input)
+	if err != nil {
+		t.Fatalf("expected unknown options to be ignored, got %v", err)
+	}
+	err = runCommand(t, "validate", input, "--strict")
+	if err == nil {
+		t.Fatal("expected the unknown option to fail with --strict")
+	}
+}
+
+func TestValidateLanguages(t *testing.T) {
+	dir := t.TempDir()
+	input := writeTestFile(t, dir, "input.md", "```dot render{\"mode\":\"unknown\"}\ndigraph { a }\n```\n")
+	err := runCommand(t, "validate", input, "--languages", "plantuml")
+	if err != nil {
+		t.Fatalf("expected blocks of other languages to be skipped, got %v", err)
+	}
+	err = runCommand(t, "validate", input, "--languages", "dot")
+	if err == nil {
+		t.Fatal("expected the invalid mode to fail")
+	}
+}
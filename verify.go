@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"image/png"
+	"io"
+	"os"
+	"path"
+)
+
+// HasValidOutputs checks that the chunk's previously rendered files, checked
+// with --verify-outputs, are complete, e.g. that a crash did not leave a
+// truncated SVG behind. Files that are missing are not valid either.
+func (r *Chunk) HasValidOutputs(outputDir string) bool {
+	dir := path.Join(outputDir, r.OutputSubdir())
+	if verifyOutputFile(path.Join(dir, r.OutputFilename()), r.OutputFormat()) != nil {
+		return false
+	}
+	if r.HasFallback() && verifyOutputFile(path.Join(dir, r.FallbackFilename()), "png") != nil {
+		return false
+	}
+	if r.HasViewer() && verifyOutputFile(path.Join(dir, r.ViewerFilename()), "html") != nil {
+		return false
+	}
+	for _, v := range r.ViewFilenames() {
+		if verifyOutputFile(path.Join(dir, v), r.OutputFormat()) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyOutputFile checks that a rendered file is not empty, and is well-formed
+// for its format.
+func verifyOutputFile(filePath string, ext string) error {
+	b, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(b)) == 0 {
+		return errors.New("file is empty")
+	}
+	switch ext {
+	case "svg":
+		return verifySVG(b)
+	case "png":
+		// Decode the whole image, since a truncated PNG has a valid header
+		_, err := png.Decode(bytes.NewReader(b))
+		return err
+	case "json":
+		if !json.Valid(b) {
+			return errors.New("invalid json")
+		}
+	}
+	return nil
+}
+
+// verifySVG checks that the content is well-formed XML with an svg root
+// element.
+func verifySVG(content []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	hasRoot := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if start, ok := token.(xml.StartElement); ok && !hasRoot {
+			if start.Name.Local != "svg" {
+				return errors.New("root element is not svg")
+			}
+			hasRoot = true
+		}
+	}
+	if !hasRoot {
+		return errors.New("no svg element")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// The script providing pan and zoom in viewer pages
+const svgPanZoomScriptURL = "https://cdn.jsdelivr.net/npm/svg-pan-zoom@3.6.1/dist/svg-pan-zoom.min.js"
+
+// HasViewer checks if the chunk's image links to a standalone viewer page,
+// set with the viewer option.
+func (r *Chunk) HasViewer() bool {
+	return r.RenderOptions.Viewer
+}
+
+// validateViewer checks that the chunk's image can link to a viewer page.
+// Only SVG images in Markdown files, with the default image syntax or
+// --hash-style attribute, can be linked.
+func (r *Chunk) validateViewer() error {
+	if r.IsDataOutput() || r.OutputFormat() != "svg" {
+		return errors.New("viewer is only supported for svg images")
+	}
+	if _, ok := r.Format.(MarkdownFormat); !ok || imageTemplate != nil {
+		return errors.New("viewer is only supported for markdown images without an image template")
+	}
+	return nil
+}
+
+// ViewerFilename returns the filename the chunk's viewer page is written to,
+// which is the filename of the SVG with the extension replaced.
+func (r *Chunk) ViewerFilename() string {
+	return strings.TrimSuffix(r.OutputFilename(), ".svg") + ".html"
+}
+
+// hasViewerLink checks if the chunk's previously rendered image line links to
+// its viewer page.
+func (r *Chunk) hasViewerLink() bool {
+	return strings.Contains(r.Lines[r.ImageRelativeLineIndex], r.ViewerFilename())
+}
+
+// buildViewerPage builds a standalone HTML page displaying the SVG with pan
+// and zoom. The SVG is embedded in the page, so that the page can be opened
+// on its own.
+func buildViewerPage(title string, svg []byte) []byte {
+	content := string(svg)
+	// Drop the XML declaration and doctype, which are not allowed in HTML
+	if loc := svgRootTagRegexp.FindStringIndex(content); loc != nil {
+		content = content[loc[0]:]
+	}
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	b.WriteString("<style>html, body, #diagram { margin: 0; width: 100%; height: 100%; } #diagram svg { width: 100%; height: 100%; }</style>\n")
+	fmt.Fprintf(&b, "<script src=\"%s\"></script>\n", svgPanZoomScriptURL)
+	b.WriteString("</head>\n<body>\n<div id=\"diagram\">\n")
+	b.WriteString(strings.TrimSpace(content))
+	b.WriteString("\n</div>\n<script>svgPanZoom(\"#diagram svg\", {controlIconsEnabled: true, fit: true, center: true});</script>\n</body>\n</html>\n")
+	return []byte(b.String())
+}
+
+// buildViewerLink wraps the image in a link to the viewer page. HTML images
+// are wrapped in an HTML link, since the link would not be parsed around
+// them otherwise.
+func buildViewerLink(image, viewerFilename, linkPrefix string) string {
+	if strings.HasPrefix(image, "<") {
+		return fmt.Sprintf(`<a href="%s">%s</a>`, linkPrefix+viewerFilename, image)
+	}
+	return fmt.Sprintf("[%s](%s)", image, linkPrefix+viewerFilename)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func NewWarmCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "warm-cache",
+		Short: "Render the code blocks in markdown files into the cache, without writing images or modifying the files",
+		Long:  ``,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && config.FilesFrom == "" {
+				return errors.New("no files specified as input")
+			}
+			return nil
+		},
+		RunE: warmCacheCmd,
+	}
+	addRenderFlags(cmd)
+	return cmd
+}
+
+func warmCacheCmd(cmd *cobra.Command, args []string) error {
+	err := prepareRender()
+	if err != nil {
+		return err
+	}
+	if config.Render.CacheDir == "" {
+		return errors.New("no cache directory specified, set --cache-dir or render.cacheDir in the config file")
+	}
+	args, err = inputFiles(args)
+	if err != nil {
+		return err
+	}
+	languages := strings.Split(config.Render.Languages, ",")
+	for _, v := range args {
+		filePath := resolveRootPath(v)
+		err := warmCacheFile(filePath, languages)
+		if _, ok := err.(*SourceError); ok {
+			// Already identifies the file
+			return err
+		}
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("warm cache for file %s", v))
+		}
+	}
+	return nil
+}
+
+// warmCacheFile renders every code block in the file into the cache, whether
+// or not its image is up to date, since the images may not be available. The
+// PNG fallbacks of --picture are rendered too.
+func warmCacheFile(filePath string, types []string) error {
+	content, err := readInputFile(filePath)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(content, "\n")
+
+	format, err := inputFormatFromPath(filePath)
+	if err != nil {
+		return err
+	}
+	sidecar, err := loadSidecar(filePath)
+	if err != nil {
+		return err
+	}
+	chunks, err := splitChunks(format, lines, types, filePath, sidecar)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if !chunk.IsRenderable {
+			continue
+		}
+		if config.Render.OnlyLanguages != "" && !listContains(config.Render.OnlyLanguages, chunk.Language) {
+			continue
+		}
+		if !chunk.IsSelectedByTags() {
+			continue
+		}
+		// Trim as when rendering, so that the cache key matches
+		if config.Render.TrimTrailingWhitespace {
+			chunk.TrimTrailingWhitespace()
+		}
+		_, err := chunk.renderContent()
+		if err == nil && chunk.HasFallback() {
+			_, err = chunk.fallbackChunk().renderContent()
+			if err != nil {
+				err = errors.Wrap(err, "render fallback")
+			}
+		}
+		if err != nil {
+			err = chunk.LocateRenderError(filePath, err)
+			if _, ok := err.(*SourceError); ok {
+				return err
+			}
+			return errors.Wrap(err, fmt.Sprintf("line %d: render chunk", chunk.CodeBlockIndex+1))
+		}
+		logf("[%s:%d] Cached %s code block\n", filePath, chunk.CodeBlockIndex+1, chunk.Language)
+	}
+	return nil
+}
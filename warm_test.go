@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWarmCacheRendersFallbacks(t *testing.T) {
+	renderCountPath := filepath.Join(t.TempDir(), "count")
+	// Counts the renders, and outputs an SVG or a PNG depending on -T
+	installStubRenderer(t, "dot", `cat >/dev/null; echo >>`+renderCountPath+`
+case "$*" in
+*-Tpng*) printf '\211PNG\r\n\032\n' ;;
+*) echo '<svg xmlns="http://www.w3.org/2000/svg"></svg>' ;;
+esac`)
+	dir := t.TempDir()
+	cacheDir := t.TempDir()
+	filePath := writeTestFile(t, dir, "doc.md", "```dot render\ndigraph { a }\n```\n")
+
+	err := runCommand(t, "warm-cache", "--languages", "dot", "--cache-dir", cacheDir, "--output-dir", dir, "--picture", filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(renderCountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2 {
+		t.Errorf("expected the image and its fallback to be rendered, got %d renders", len(b))
+	}
+
+	// Rendering from the cache does not run the renderer
+	err = os.Remove(renderCountPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = runCommand(t, "render", "--languages", "dot", "--cache-dir", cacheDir, "--output-dir", dir, "--picture", filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(renderCountPath); !os.IsNotExist(err) {
+		t.Error("expected the image and its fallback to be rendered from the cache")
+	}
+}